@@ -0,0 +1,204 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/perillo/go-compatible/compatible"
+	"github.com/perillo/go-compatible/internal/logging"
+	"github.com/perillo/go-compatible/version"
+)
+
+// civersion is a go-version entry in a GitHub Actions workflow.
+type civersion struct {
+	name  string          // the entry, as written in the workflow
+	v     version.Version // the version, with a zero patch for a minor
+	minor bool            // the entry selects the latest patch of a minor
+}
+
+// match returns true if rel is selected by the entry.
+func (cv civersion) match(rel compatible.Release) bool {
+	if rel.Devel {
+		return false
+	}
+	if cv.minor {
+		return rel.Version.CompareMinor(cv.v) == 0 && !rel.Version.IsPreRelease()
+	}
+
+	return rel.Version.Equal(cv.v)
+}
+
+// readworkflow returns the go-version entries used in the GitHub Actions
+// workflow file.  Entries that are not versions, like "stable" or ranges, are
+// ignored with a warning.
+func readworkflow(file string) ([]civersion, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := goversions(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	var list []civersion
+	for _, entry := range entries {
+		cv, err := parseci(entry)
+		if err != nil {
+			logging.Warnf("%s: ignoring go-version %s: %v", file, entry, err)
+
+			continue
+		}
+		list = append(list, cv)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s: no go-version entries found", file)
+	}
+
+	return list, nil
+}
+
+// goversions returns the values of the go-version keys in the workflow read
+// from r, like the go-version matrix of a job or the go-version input of the
+// actions/setup-go action.  Both the inline and the block list styles are
+// supported.  Values using an expression, like ${{ matrix.go }}, are ignored.
+//
+// This is not a YAML parser; it only supports the common ways the go-version
+// key is written.
+func goversions(r io.Reader) ([]string, error) {
+	var list []string
+	seen := make(map[string]bool)
+	add := func(s string) {
+		s = unquote(s)
+		if s != "" && !strings.Contains(s, "${{") && !seen[s] {
+			list = append(list, s)
+			seen[s] = true
+		}
+	}
+
+	block := -1 // indentation of the go-version key with a block list
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := stripcomment(sc.Text())
+		text := strings.TrimSpace(line)
+		if text == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if block >= 0 {
+			if indent > block && strings.HasPrefix(text, "-") {
+				add(strings.TrimPrefix(text, "-"))
+
+				continue
+			}
+			block = -1
+		}
+
+		text = strings.TrimPrefix(text, "- ")
+		if !strings.HasPrefix(text, "go-version:") {
+			continue
+		}
+		value := strings.TrimSpace(strings.TrimPrefix(text, "go-version:"))
+		switch {
+		case value == "":
+			block = indent
+		case strings.HasPrefix(value, "["):
+			value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+			for _, s := range strings.Split(value, ",") {
+				add(s)
+			}
+		default:
+			add(value)
+		}
+	}
+
+	return list, sc.Err()
+}
+
+// stripcomment returns line without a trailing YAML comment.
+func stripcomment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	if i := strings.Index(line, " #"); i >= 0 {
+		return line[:i]
+	}
+
+	return line
+}
+
+// unquote returns s with white space and the YAML quotes removed.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+// parseci parses a go-version entry, like "1.20", "1.20.x" or "1.21.3".  An
+// entry without the patch selects the latest patch of the minor, as done by
+// actions/setup-go.
+func parseci(entry string) (civersion, error) {
+	wildcard := strings.HasSuffix(entry, ".x")
+	s := strings.TrimSuffix(entry, ".x")
+	minor := wildcard || strings.Count(s, ".") == 1
+	if !strings.HasPrefix(s, "go") {
+		s = "go" + s
+	}
+	v, err := version.Parse(s)
+	if err != nil {
+		return civersion{}, err
+	}
+	if v.IsPreRelease() {
+		// A pre-release, like "1.21rc2", is always an exact version.
+		if wildcard {
+			return civersion{}, fmt.Errorf("invalid pre-release")
+		}
+		minor = false
+	}
+
+	return civersion{name: entry, v: v, minor: minor}, nil
+}
+
+// selectci returns the releases, in order, selected by the go-version entries
+// and the entries that do not match any release.  An entry for a minor
+// selects only its latest patch.
+func selectci(releases []compatible.Release, entries []civersion) ([]compatible.Release, []string) {
+	selected := make([]bool, len(releases))
+	var missing []string
+	for _, cv := range entries {
+		index := -1
+		for i, rel := range releases {
+			// The releases may be sorted in descending order.
+			if cv.match(rel) && (index < 0 || releases[index].Version.Less(rel.Version)) {
+				index = i
+			}
+		}
+		if index < 0 {
+			missing = append(missing, cv.name)
+
+			continue
+		}
+		selected[index] = true
+	}
+
+	var list []compatible.Release
+	for i, rel := range releases {
+		if selected[i] {
+			list = append(list, rel)
+		}
+	}
+
+	return list, missing
+}
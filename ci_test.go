@@ -0,0 +1,134 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/perillo/go-compatible/compatible"
+	"github.com/perillo/go-compatible/internal/fakesdk"
+	"github.com/perillo/go-compatible/version"
+)
+
+// workflow is a sample GitHub Actions workflow, using both the inline and the
+// block list styles.
+const workflow = `name: test
+on: [push, pull_request]
+jobs:
+  test:
+    strategy:
+      matrix:
+        os: [ubuntu-latest, windows-latest]
+        go-version: [1.18, '1.19', "1.20.x"] # supported releases
+    runs-on: ${{ matrix.os }}
+    steps:
+      - uses: actions/setup-go@v4
+        with:
+          go-version: ${{ matrix.go-version }}
+      - run: go test ./...
+  latest:
+    strategy:
+      matrix:
+        go-version:
+          - 1.21.3
+          # - 1.22rc1
+          - stable
+          - 1.19
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/setup-go@v4
+        with:
+          go-version: 1.17
+`
+
+// TestGoversions tests that the go-version entries are found in the sample
+// workflow.
+func TestGoversions(t *testing.T) {
+	list, err := goversions(strings.NewReader(workflow))
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want := []string{"1.18", "1.19", "1.20.x", "1.21.3", "stable", "1.17"}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("want entries = %q, got %q", want, list)
+	}
+}
+
+// TestParseci tests the parsing of the go-version entries.
+func TestParseci(t *testing.T) {
+	var tests = []struct {
+		entry string
+		v     string
+		minor bool
+	}{
+		{"1.20", "go1.20", true},
+		{"1.20.x", "go1.20", true},
+		{"go1.20", "go1.20", true},
+		{"1.21.3", "go1.21.3", false},
+		{"1.21.0", "go1.21.0", false},
+		{"1.21rc2", "go1.21rc2", false},
+	}
+	for _, test := range tests {
+		cv, err := parseci(test.entry)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.entry, err)
+		}
+		if v := version.Must(version.Parse(test.v)); cv.v != v {
+			t.Errorf("%s: want v = %v, got %v", test.entry, v, cv.v)
+		}
+		if cv.minor != test.minor {
+			t.Errorf("%s: want minor = %t, got %t", test.entry, test.minor,
+				cv.minor)
+		}
+	}
+
+	for _, entry := range []string{"stable", "oldstable", ">=1.20", "1.21rc2.x"} {
+		if _, err := parseci(entry); err == nil {
+			t.Errorf("%s: expected err != nil", entry)
+		}
+	}
+}
+
+// TestSelectci tests that the releases in the sample workflow are selected
+// from a fake sdk, and that the entries not installed are reported as
+// missing.
+func TestSelectci(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.yml")
+	if err := os.WriteFile(file, []byte(workflow), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readworkflow(file)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	sdk := fakesdk.SDK(t, "go1.16", "go1.18", "go1.19.1", "go1.19.2", "go1.20",
+		"go1.20.5", "go1.21.0", "go1.21.3", "go1.22rc1")
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	releases, missing := selectci(releases, entries)
+	fakesdk.ValidateReleases(t, releases, []string{"go1.18", "go1.19.2", "go1.20.5",
+		"go1.21.3"})
+	if want := []string{"1.17"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("want missing = %q, got %q", want, missing)
+	}
+
+	// The latest patch is selected also in descending order.
+	releases, err = compatible.DiscoverReleases(sdk, compatible.Filter{
+		Descending: true,
+	})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	releases, _ = selectci(releases, entries)
+	fakesdk.ValidateReleases(t, releases, []string{"go1.21.3", "go1.20.5", "go1.19.2",
+		"go1.18"})
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/perillo/go-compatible/compatible"
+)
+
+// ANSI escape sequences used to color the output.
+const (
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	reset  = "\x1b[0m"
+)
+
+// usecolor reports whether the output written to f should be colored,
+// according to the value of the -color flag: "always", "never" or "auto".  In
+// auto mode the output is colored only if f is a terminal.
+func usecolor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isterminal(f), nil
+	}
+
+	return false, fmt.Errorf("must be \"auto\", \"always\" or \"never\"")
+}
+
+// isterminal returns true if f is a terminal.
+func isterminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps s in the specified color, if enabled is true.
+func paint(s, color string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+
+	return color + s + reset
+}
+
+// statuscolor returns the color used for the specified status.
+func statuscolor(s compatible.Status) string {
+	switch s {
+	case compatible.Pass:
+		return green
+	case compatible.Fail:
+		return red
+	}
+
+	return yellow
+}
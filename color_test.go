@@ -0,0 +1,71 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perillo/go-compatible/compatible"
+)
+
+// TestUseColor tests that the output is not colored in auto mode when the
+// writer is not a terminal.
+func TestUseColor(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var tests = []struct {
+		mode string
+		want bool
+	}{
+		{"auto", false},
+		{"always", true},
+		{"never", false},
+	}
+	for _, test := range tests {
+		got, err := usecolor(test.mode, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.mode, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: want color %t, got %t", test.mode, test.want, got)
+		}
+	}
+
+	if _, err := usecolor("sometimes", f); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestSummaryColor tests that the status in the summary is colored only when
+// out.color is set.
+func TestSummaryColor(t *testing.T) {
+	results := []compatible.Result{
+		newresult("go1.16", compatible.Pass, "", ""),
+		newresult("go1.17", compatible.Fail, "diagnostics found", ""),
+	}
+
+	buf := new(bytes.Buffer)
+	printsummary(buf, results, compatible.Options{}, output{})
+	if s := buf.String(); strings.Contains(s, "\x1b[") {
+		t.Errorf("expected summary without color, got %q", s)
+	}
+
+	buf.Reset()
+	printsummary(buf, results, compatible.Options{}, output{color: true})
+	want := "go1.16  " + green + "PASS" + reset + "  0s\n" +
+		"go1.17  " + red + "FAIL" + reset + "  0s  diagnostics found\n" +
+		"total   " + green + reset + "      0s\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want summary = %q, got %q", want, s)
+	}
+}
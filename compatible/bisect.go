@@ -0,0 +1,105 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errNoChange is returned by bisect when the first and the last element have
+// the same outcome.
+var errNoChange = errors.New("the oldest and the newest release have the same result")
+
+// bisect binary searches the index i in [1, n) where the outcome of failed
+// changes, so that failed(i-1) != failed(i), assuming that the outcome changes
+// only once.  It works both when the outcome changes from pass to fail and
+// from fail to pass, and calls failed O(log n) times.
+func bisect(n int, failed func(i int) (bool, error)) (int, error) {
+	if n < 2 {
+		return 0, fmt.Errorf("bisect: at least 2 releases are required")
+	}
+
+	lo, hi := 0, n-1
+	first, err := failed(lo)
+	if err != nil {
+		return 0, err
+	}
+	last, err := failed(hi)
+	if err != nil {
+		return 0, err
+	}
+	if first == last {
+		return 0, errNoChange
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		f, err := failed(mid)
+		if err != nil {
+			return 0, err
+		}
+		if f == first {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return hi, nil
+}
+
+// Bisect invokes go vet, go build or go test on the releases selected by a
+// binary search for the release where the result changes.  It returns the
+// results sorted by release, and the index of the result of the first release
+// where the result changes.
+//
+// Bisect supports only one target platform.
+func Bisect(ctx context.Context, releases []Release, patterns []string, opts Options) ([]Result, int, error) {
+	if len(opts.Platforms) > 1 {
+		return nil, 0, fmt.Errorf("bisect: only one target platform is supported")
+	}
+	plat := Platform{} // host platform
+	if len(opts.Platforms) == 1 {
+		plat = opts.Platforms[0]
+	}
+
+	tool := toolfor(opts)
+	cache := make(map[int]Result)
+	change, err := bisect(len(releases), func(i int) (bool, error) {
+		res, err := runtool(ctx, tool, releases[i], plat, patterns, opts)
+		if err != nil {
+			return false, err
+		}
+		cache[i] = res
+
+		return res.Status == Fail, nil
+	})
+
+	// Sort the results by release.
+	indexes := make([]int, 0, len(cache))
+	for i := range cache {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	results := make([]Result, 0, len(indexes))
+	index := 0
+	for _, i := range indexes {
+		if i == change {
+			index = len(results)
+		}
+		results = append(results, cache[i])
+	}
+	if err != nil {
+		return results, 0, err
+	}
+
+	if needclean(opts) {
+		return results, index, goclean(opts.Dir)
+	}
+
+	return results, index, nil
+}
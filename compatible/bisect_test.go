@@ -0,0 +1,100 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/perillo/go-compatible/internal/fakesdk"
+)
+
+// TestBisect tests the bisect function using a synthetic pass/fail predicate,
+// for both transitions from pass to fail and from fail to pass.
+func TestBisect(t *testing.T) {
+	var tests = []struct {
+		outcome string // f means fail, p means pass
+		want    int
+	}{
+		{"pf", 1},
+		{"fp", 1},
+		{"ppppffff", 4},
+		{"pppppppf", 7},
+		{"pfffffff", 1},
+		{"fffffffp", 7},
+		{"ffpppppppppppppp", 2},
+	}
+	for _, test := range tests {
+		calls := 0
+		i, err := bisect(len(test.outcome), func(i int) (bool, error) {
+			calls++
+
+			return test.outcome[i] == 'f', nil
+		})
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.outcome, err)
+		}
+		if i != test.want {
+			t.Errorf("%s: want %d, got %d", test.outcome, test.want, i)
+		}
+		if max := 2 + log2(len(test.outcome)); calls > max {
+			t.Errorf("%s: want at most %d calls, got %d", test.outcome, max,
+				calls)
+		}
+	}
+
+	_, err := bisect(4, func(i int) (bool, error) { return false, nil })
+	if !errors.Is(err, errNoChange) {
+		t.Errorf("want err = %v, got %v", errNoChange, err)
+	}
+}
+
+// log2 returns the ceiling of the base 2 logarithm of n.
+func log2(n int) int {
+	k := 0
+	for 1<<k < n {
+		k++
+	}
+
+	return k
+}
+
+// TestRunBisect tests that Bisect reports the adjacent releases where the
+// result changes, and the results of the tested releases.
+func TestRunBisect(t *testing.T) {
+	releases := []Release{
+		fakeRelease(t, "go1.14", "echo FAIL; exit 1"),
+		fakeRelease(t, "go1.15", "echo FAIL; exit 1"),
+		fakeRelease(t, "go1.16", "exit 0"),
+		fakeRelease(t, "go1.17", "exit 0"),
+		fakeRelease(t, "go1.18", "exit 0"),
+	}
+	opts := Options{Mode: "test"}
+	results, index, err := Bisect(context.Background(), releases,
+		[]string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releaselist(results), []string{"go1.14", "go1.15",
+		"go1.16", "go1.18"})
+
+	if s := results[index-1].Release.String(); s != "go1.15" {
+		t.Errorf("want results[index-1] = %s, got %s", "go1.15", s)
+	}
+	if s := results[index].Release.String(); s != "go1.16" {
+		t.Errorf("want results[index] = %s, got %s", "go1.16", s)
+	}
+}
+
+// releaselist returns the release of each result.
+func releaselist(results []Result) []Release {
+	list := make([]Release, 0, len(results))
+	for _, res := range results {
+		list = append(list, res.Release)
+	}
+
+	return list
+}
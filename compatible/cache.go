@@ -0,0 +1,139 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/perillo/go-compatible/internal/logging"
+)
+
+// VersionCache is the path of the file where DiscoverReleases caches the
+// version line reported by go version for each goroot, so that the go command
+// is not invoked again on the next runs.  An empty path disables the cache.
+var VersionCache = ""
+
+// cacheentry is the cached version line of a go command.  The entry is valid
+// only if the modification time and the size of the go command did not
+// change.
+type cacheentry struct {
+	ModTime time.Time `json:"mtime"`
+	Size    int64     `json:"size"`
+	Line    string    `json:"line"`
+}
+
+// versioncache maps the path of a go command to its cached version line.
+type versioncache struct {
+	file    string
+	entries map[string]cacheentry
+	dirty   bool // the entries must be saved
+}
+
+// loadcache reads the version cache from file.  A missing file means an empty
+// cache, and an invalid file is ignored with a warning, since the cache is
+// only an optimization.
+func loadcache(file string) *versioncache {
+	c := &versioncache{
+		file:    file,
+		entries: make(map[string]cacheentry),
+	}
+	if file == "" {
+		return c
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Warnf("version cache: %v", err)
+		}
+
+		return c
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		logging.Warnf("version cache %s: %v", file, err)
+		c.entries = make(map[string]cacheentry)
+	}
+
+	return c
+}
+
+// goversion is like the goversion function, but it returns the cached version
+// line when the go command from goroot did not change.
+func (c *versioncache) goversion(goroot string) (string, error) {
+	if c.file == "" {
+		return goversion(goroot)
+	}
+
+	gocmd := gocommand(goroot)
+	fi, err := os.Stat(gocmd)
+	if err != nil {
+		// Let goversion report the error.
+		return goversion(goroot)
+	}
+	entry, ok := c.entries[gocmd]
+	if ok && entry.ModTime.Equal(fi.ModTime()) && entry.Size == fi.Size() {
+		logging.Debugf("version cache: using the cached version of %s", gocmd)
+
+		return entry.Line, nil
+	}
+
+	line, err := goversion(goroot)
+	if err != nil {
+		return "", err
+	}
+	c.entries[gocmd] = cacheentry{
+		ModTime: fi.ModTime(),
+		Size:    fi.Size(),
+		Line:    line,
+	}
+	c.dirty = true
+
+	return line, nil
+}
+
+// save writes the version cache to its file, if it changed.  The file is
+// replaced atomically, so that concurrent runs never read a partial file.
+// Errors are logged as warnings.
+func (c *versioncache) save() {
+	if c.file == "" || !c.dirty {
+		return
+	}
+
+	if err := c.write(); err != nil {
+		logging.Warnf("version cache: %v", err)
+	}
+}
+
+// write writes the version cache to its file.
+func (c *versioncache) write() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(c.file)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(c.file)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), c.file)
+}
@@ -0,0 +1,856 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package compatible provides support for checking if a package is compatible
+// with older versions of Go.
+//
+// Internally, it invokes `go vet`, `go build` or `go test` on all the
+// available releases installed on the system.
+package compatible
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perillo/go-compatible/internal/logging"
+	"github.com/perillo/go-compatible/version"
+)
+
+// Release is a go release installed in the sdk directory.
+type Release struct {
+	GoRoot  string
+	Version version.Version
+	Devel   bool // a development version, like gotip
+}
+
+// String returns the name of the release, like "go1.16" or "devel go1.18".
+func (r Release) String() string {
+	if r.Devel {
+		if r.Version == (version.Version{}) {
+			return "devel"
+		}
+
+		return "devel go" + r.Version.String()
+	}
+
+	return "go" + r.Version.String()
+}
+
+// less returns true if r is older than s.  A development version is more
+// recent than all the releases.
+func (r Release) less(s Release) bool {
+	if r.Devel != s.Devel {
+		return s.Devel
+	}
+
+	return r.Version.Less(s.Version)
+}
+
+// Platform represents a target platform.  An empty field means the default
+// value used by the go command.
+type Platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+// String returns the platform as "goos/goarch", using the host operating
+// system or architecture in case of an empty field.
+func (p Platform) String() string {
+	p = p.resolve()
+
+	return p.GOOS + "/" + p.GOARCH
+}
+
+// resolve returns p with the empty fields set to the host operating system
+// and architecture.
+func (p Platform) resolve() Platform {
+	if p.GOOS == "" {
+		p.GOOS = runtime.GOOS
+	}
+	if p.GOARCH == "" {
+		p.GOARCH = runtime.GOARCH
+	}
+
+	return p
+}
+
+// Filter configures how the releases in the sdk are selected.
+type Filter struct {
+	Since  version.Version // the oldest release to use
+	Latest int             // use only the N most recent releases, if > 0
+	Minor  bool            // use only the most recent release of each minor
+	Stable bool            // exclude pre-releases
+
+	Constraint version.Constraint // use only the matching releases
+	Pattern    *regexp.Regexp     // use only the releases with a matching version
+	Glob       version.Glob       // use only the releases matching a glob pattern
+	Exclude    []version.Version  // exclude the releases with these versions
+
+	FailDuplicates bool // duplicate releases are an error, instead of a warning
+
+	// ExcludeDevel excludes the development versions, like gotip, in
+	// addition to the releases in Exclude.
+	ExcludeDevel bool
+
+	// SincePreReleases includes the pre-releases of Since, comparing Since
+	// with the release of each pre-release, so that go1.21rc1 is selected
+	// by go1.21.  The pre-releases of older releases are never selected.
+	SincePreReleases bool
+
+	// Descending sorts the releases from the most recent to the oldest.
+	// The other filters are applied first, so that with Latest the N most
+	// recent releases come first.
+	Descending bool
+
+	// Layout is a glob pattern, as used by filepath.Match, that the name of
+	// a goroot in the sdk directory must match, like "go-*".  An empty
+	// Layout means "go*".
+	Layout string
+}
+
+// Options configures how the go tool is invoked.
+type Options struct {
+	Mode      string        // vet, build, test or auto
+	Timeout   time.Duration // 0 means no timeout
+	Platforms []Platform    // nil means the host platform
+	Race      bool          // enable the race detector in test mode
+	Args      []string      // additional arguments for the go tool
+	DryRun    bool          // print the commands without running them
+	Stdout    io.Writer     // where the dry run commands are printed, nil means os.Stdout
+	Dir       string        // working directory, empty means current
+	Tags      string        // build tags
+	Env       []string      // additional environment variables, as KEY=VALUE
+	CleanEnv  bool          // do not inherit the environment, except for PATH and HOME
+	Proxy     string        // GOPROXY value, like off, empty means inherited
+	SumDB     string        // GOSUMDB value, like off, empty means inherited
+	Workspace bool          // dir is the root of a workspace
+	FirstFail bool          // stop after the first failed release
+	Retries   int           // number of retries for a failed release
+	KeepGoing bool          // skip the releases with fatal errors
+	CacheDir  string        // root of a build cache per release, empty means the default
+	Command   []string      // custom go subcommand and arguments, overriding Mode
+	Analyzers []string      // vet analyzers, like printf or shadow=false
+	NoTests   bool          // report the packages without test files in test mode
+	Shuffle   string        // go test -shuffle value, like on or a seed, empty means off
+
+	// Ignore, if not nil, removes the lines matching the regular
+	// expression from the diagnostic message, like the known warnings of
+	// old releases.  A release whose diagnostic message only has ignored
+	// lines passes.
+	Ignore *regexp.Regexp
+
+	// PkgTimeout, if greater than 0, is passed to go test with the -timeout
+	// flag, so that a test binary panics when it runs longer than this
+	// duration.  Unlike Timeout, it does not kill the go command.
+	PkgTimeout time.Duration
+
+	// PkgParallel, if greater than 1, splits the packages named by the
+	// patterns in PkgParallel shards, invoking the tool concurrently on each
+	// shard of a release.
+	PkgParallel int
+
+	// VersionEnv sets the environment variables required by old releases
+	// to behave like the newer ones, like GO111MODULE=on for go1.11.
+	VersionEnv bool
+
+	// Report, if not nil, is called by Run with the events of each release
+	// and platform, as they happen, so that the progress can be rendered
+	// while the releases are checked.
+	Report ReportFunc
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	EventStarted    EventKind = iota // the tool is about to be invoked
+	EventDiagnostic                  // the tool reported a diagnostic message
+	EventFinished                    // the tool completed
+)
+
+// String implements the Stringer interface.
+func (k EventKind) String() string {
+	switch k {
+	case EventStarted:
+		return "started"
+	case EventDiagnostic:
+		return "diagnostic"
+	case EventFinished:
+		return "finished"
+	}
+
+	return "event(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event is an event reported by Run for a release and platform.  For each
+// invocation of the tool, Run reports an EventStarted event, an
+// EventDiagnostic event if the result has a diagnostic message, and an
+// EventFinished event, unless Run returns an error.
+type Event struct {
+	Kind     EventKind
+	N        int // invocation number, starting from 1
+	Total    int // total number of invocations
+	Release  Release
+	Platform Platform
+	Result   Result // the result, except for EventStarted
+}
+
+// ReportFunc is the function called with the events reported by Run.  It is
+// called from the goroutine calling Run.
+type ReportFunc func(Event)
+
+// Status is the outcome of the verification of a release.
+type Status int
+
+const (
+	Pass Status = iota
+	Fail
+	Skip
+)
+
+// String implements the Stringer interface.
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	}
+
+	return "status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Result is the result of the verification of a release for a platform.
+type Result struct {
+	Release  Release
+	Platform Platform
+	Msg      []byte        // diagnostic message or test report
+	Tool     string        // vet, build, test or the custom subcommand
+	Duration time.Duration // wall-clock duration of the tool invocation
+	Status   Status
+	Reason   string // short reason for a failure or a skip
+}
+
+// Counts is the number of results with each status.
+type Counts struct {
+	Total   int
+	Passed  int
+	Failed  int
+	Skipped int
+}
+
+// Count returns the number of results with each status.
+func Count(results []Result) Counts {
+	c := Counts{Total: len(results)}
+	for _, res := range results {
+		switch res.Status {
+		case Pass:
+			c.Passed++
+		case Fail:
+			c.Failed++
+		case Skip:
+			c.Skipped++
+		}
+	}
+
+	return c
+}
+
+// skipError is the error returned by a tool function when a release must be
+// skipped.
+type skipError struct {
+	reason string
+}
+
+// Error implements the error interface.
+func (e *skipError) Error() string {
+	return "skipped: " + e.reason
+}
+
+// errFailed is the error returned by gocustom when the custom command fails.
+// Since the output of a custom command is reported also when it succeeds, a
+// failure can not be detected by the diagnostic message.
+var errFailed = errors.New("command failed")
+
+// errNoTests is the error returned by gotest when opts.NoTests is set and go
+// test succeeds, but some packages have no test files.  The packages are only
+// compiled, so they are reported without failing the release.
+var errNoTests = errors.New("no test files")
+
+// Run invokes go vet, go build or go test for all the specified releases and
+// target platforms, and returns the result for each release and platform.  It
+// returns the results collected so far and ctx.Err() if ctx becomes done
+// before all the releases have been processed.
+//
+// If opts.Timeout is not 0, the tool invoked for a release is killed when the
+// timeout expires, and the timeout is reported as the release diagnostic.  If
+// opts.FirstFail is set, Run stops after the first release that fails; since
+// the releases are sorted, this is the oldest failing release.
+func Run(ctx context.Context, releases []Release, patterns []string, opts Options) ([]Result, error) {
+	tool := toolfor(opts)
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = []Platform{{}} // host platform
+	}
+
+	total := len(releases) * len(platforms)
+	results := make([]Result, 0, total)
+loop:
+	for _, rel := range releases {
+		for _, plat := range platforms {
+			ev := Event{
+				N:        len(results) + 1,
+				Total:    total,
+				Release:  rel,
+				Platform: plat,
+			}
+			report(opts, ev)
+			res, err := runtool(ctx, tool, rel, plat, patterns, opts)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, res)
+			ev.Result = res
+			if res.Msg != nil {
+				ev.Kind = EventDiagnostic
+				report(opts, ev)
+			}
+			ev.Kind = EventFinished
+			report(opts, ev)
+			if opts.FirstFail && res.Status == Fail {
+				break loop
+			}
+		}
+	}
+
+	if needclean(opts) {
+		return results, goclean(opts.Dir)
+	}
+
+	return results, nil
+}
+
+// report calls opts.Report with ev, if set.
+func report(opts Options, ev Event) {
+	if opts.Report != nil {
+		opts.Report(ev)
+	}
+}
+
+// toolfor returns the tool function for opts.Command, if set, or opts.Mode.
+// When opts.PkgParallel is greater than 1, the tool is invoked concurrently on
+// shards of the packages.
+func toolfor(opts Options) toolfunc {
+	tool := modetool(opts)
+	if opts.PkgParallel > 1 {
+		return sharded(tool, opts.PkgParallel)
+	}
+
+	return tool
+}
+
+// modetool returns the tool function for opts.Command, if set, or opts.Mode.
+func modetool(opts Options) toolfunc {
+	if len(opts.Command) > 0 {
+		return gocustom
+	}
+	switch opts.Mode {
+	case "build":
+		return gobuild
+	case "test":
+		return gotest
+	case "auto":
+		return goauto
+	}
+
+	return govet
+}
+
+// needclean returns true if go build is invoked by opts, writing the
+// executables in the working directory, so that go clean must be invoked at
+// the end.
+func needclean(opts Options) bool {
+	if len(opts.Command) > 0 || opts.DryRun {
+		return false
+	}
+
+	return opts.Mode == "build" || opts.Mode == "auto"
+}
+
+// retrydelay is the delay before the first retry of a failed release.  The
+// delay increases linearly with each retry.
+var retrydelay = time.Second
+
+// runtool invokes tool for the specified release and platform, killing it if
+// it does not complete within opts.Timeout.  A timeout is not considered a
+// fatal error.  If opts.KeepGoing is set, a fatal error is reported as a
+// skipped release with a warning.
+//
+// When the tool reports a failure, other than a timeout, it is invoked again
+// up to opts.Retries times.  Fatal errors are never retried.
+func runtool(ctx context.Context, tool toolfunc, rel Release, plat Platform, patterns []string, opts Options) (Result, error) {
+	for n := 1; ; n++ {
+		res, err := runonce(ctx, tool, rel, plat, patterns, opts)
+		if err != nil || res.Status != Fail || res.Reason == "timeout" ||
+			n > opts.Retries {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(time.Duration(n) * retrydelay):
+		}
+	}
+}
+
+// runonce invokes tool once for the specified release and platform, as
+// described in runtool.
+func runonce(ctx context.Context, tool toolfunc, rel Release, plat Platform, patterns []string, opts Options) (Result, error) {
+	timeout := opts.Timeout
+	tctx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	res := Result{
+		Release:  rel,
+		Platform: plat,
+		Tool:     opts.Mode,
+	}
+	if opts.Mode == "auto" {
+		res.Tool = automode(rel)
+	}
+	if len(opts.Command) > 0 {
+		res.Tool = opts.Command[0]
+	}
+	start := time.Now()
+	msg, err := tool(tctx, rel, plat, patterns, opts)
+	res.Duration = time.Since(start)
+	if opts.Ignore != nil && msg != nil {
+		msg = ignorelines(msg, opts.Ignore)
+	}
+	if err != nil {
+		var skiperr *skipError
+
+		switch {
+		case ctx.Err() != nil:
+			return res, ctx.Err()
+		case err == errFailed:
+			res.Msg = msg
+			res.Status = Fail
+			res.Reason = "command failed"
+
+			return res, nil
+		case err == errNoTests:
+			logging.Warnf("%s: packages with no test files",
+				Target(rel, plat, opts))
+			res.Msg = msg
+			res.Reason = "no test files"
+
+			return res, nil
+		case errors.Is(err, context.DeadlineExceeded):
+			res.Msg = []byte(fmt.Sprintf("timeout: killed after %v", timeout))
+			res.Status = Fail
+			res.Reason = "timeout"
+
+			return res, nil
+		case errors.As(err, &skiperr):
+			logging.Warnf("skipping %s: %s", Target(rel, plat, opts),
+				skiperr.reason)
+			res.Status = Skip
+			res.Reason = skiperr.reason
+
+			return res, nil
+		case opts.KeepGoing:
+			logging.Warnf("skipping %s: %v", Target(rel, plat, opts), err)
+			res.Status = Skip
+			res.Reason = "fatal error"
+
+			return res, nil
+		}
+
+		return res, err
+	}
+	if msg != nil {
+		res.Msg = msg
+		// The output of a successful custom command is not a failure.
+		if len(opts.Command) == 0 {
+			res.Status = Fail
+			res.Reason = failreason(msg)
+		}
+	}
+
+	return res, nil
+}
+
+// ignorelines returns msg without the lines matching re, or nil if no lines
+// remain.  The "# pkg" lines printed by the go command before the diagnostics
+// of a package are also removed, when all the diagnostics of the package are
+// ignored.
+func ignorelines(msg []byte, re *regexp.Regexp) []byte {
+	var lines []string
+	for _, line := range strings.Split(string(msg), "\n") {
+		if re.MatchString(line) {
+			continue
+		}
+		if n := len(lines); n > 0 && isheader(lines[n-1]) && isheader(line) {
+			lines[n-1] = line // the previous package has no diagnostics
+
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if n := len(lines); n > 0 && isheader(lines[n-1]) {
+		lines = lines[:n-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// isheader returns true if line is the "# pkg" line printed by the go command
+// before the diagnostics of a package.
+func isheader(line string) bool {
+	return strings.HasPrefix(line, "# ")
+}
+
+// Target returns the name of the release and platform used in the output.
+// The platform is included only when target platforms are specified, and the
+// build tags only when they are specified.
+func Target(rel Release, plat Platform, opts Options) string {
+	name := rel.String()
+	if opts.Platforms != nil {
+		name += " " + plat.String()
+	}
+	if opts.Tags != "" {
+		name += " tags=" + opts.Tags
+	}
+
+	return name
+}
+
+// toolfunc is the signature of the functions invoking a go tool, like govet.
+type toolfunc func(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error)
+
+// Platforms returns the cross product of the comma-separated lists of
+// operating systems and architectures.  It returns nil if both lists are
+// empty.
+func Platforms(goos, goarch string) []Platform {
+	if goos == "" && goarch == "" {
+		return nil
+	}
+
+	var list []Platform
+	for _, name := range strings.Split(goos, ",") {
+		for _, arch := range strings.Split(goarch, ",") {
+			p := Platform{
+				GOOS:   strings.TrimSpace(name),
+				GOARCH: strings.TrimSpace(arch),
+			}
+			list = append(list, p)
+		}
+	}
+
+	return list
+}
+
+// DiscoverReleases returns a list of all go releases in the sdk directory dir
+// selected by the specified filter, sorted in ascending order unless
+// f.Descending is set.  Only the directories with a
+// name matching f.Layout are considered.
+//
+// A goroot where the version reported by go version can not be parsed is
+// skipped with a warning.
+//
+// When two goroots report the same release, only the first one, in directory
+// order, is used and a warning is logged, unless f.FailDuplicates is set.
+//
+// The versions are cached in the VersionCache file, if set.
+func DiscoverReleases(dir string, f Filter) ([]Release, error) {
+	list := make([]Release, 0, 32) // preallocate memory
+	goroots, err := sdkgoroots(dir, f.Layout)
+	if err != nil {
+		return nil, err
+	}
+	cache := loadcache(VersionCache)
+	defer cache.save()
+	found := 0 // releases found before filtering
+	for _, goroot := range goroots {
+		line, err := cache.goversion(goroot)
+		if errors.Is(err, context.DeadlineExceeded) {
+			// A hanging go command must not abort the discovery.
+			logging.Warnf("skipping goroot %s: go version timed out", goroot)
+
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logging.Debugf("goroot %s: %s", goroot, line)
+		rel, err := parserelease(goroot, line)
+		if err != nil {
+			// Do not abort the discovery of the other releases.
+			logging.Warnf("skipping goroot %s: %v", goroot, err)
+
+			continue
+		}
+		found++
+
+		if !f.match(rel) {
+			continue
+		}
+		list = append(list, rel)
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("no go releases found in %s", dir)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no go releases in %s match the filter "+
+			"(%d excluded)", dir, found)
+	}
+
+	// Sort the releases.  The sort is stable, so that the duplicate releases
+	// are in directory order.
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].less(list[j])
+	})
+	list, err = dedup(list, f.FailDuplicates)
+	if err != nil {
+		return nil, err
+	}
+	if f.Minor {
+		list = latestpatch(list)
+	}
+	if f.Latest > 0 && f.Latest < len(list) {
+		list = list[len(list)-f.Latest:]
+	}
+	if f.Descending {
+		for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+			list[i], list[j] = list[j], list[i]
+		}
+	}
+
+	return list, nil
+}
+
+// dedup returns the sorted list of releases with the duplicate releases
+// removed, keeping the first one.  A duplicate release is logged as a
+// warning or, if fail is set, reported as an error.  Development versions are
+// never considered duplicates, since they may be built from different commits.
+func dedup(list []Release, fail bool) ([]Release, error) {
+	result := make([]Release, 0, len(list))
+	for _, rel := range list {
+		if n := len(result); n > 0 && !rel.Devel && !result[n-1].less(rel) {
+			prev := result[n-1]
+			if fail {
+				return nil, fmt.Errorf("duplicate release %s in %s and %s", rel,
+					prev.GoRoot, rel.GoRoot)
+			}
+			logging.Warnf("duplicate release %s in %s and %s, using %s",
+				rel, prev.GoRoot, rel.GoRoot, prev.GoRoot)
+
+			continue
+		}
+		result = append(result, rel)
+	}
+
+	return result, nil
+}
+
+// sdkgoroots returns the path of the directories in the sdk directory dir
+// with a name matching the layout glob pattern, "go*" if empty.
+func sdkgoroots(dir, layout string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("sdk directory %s does not exist", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if layout == "" {
+		layout = "go*"
+	}
+	if _, err := filepath.Match(layout, ""); err != nil {
+		return nil, fmt.Errorf("invalid sdk layout %q: %w", layout, err)
+	}
+
+	var list []string
+	for _, file := range files {
+		name := file.Name()
+		if ok, _ := filepath.Match(layout, name); ok && file.IsDir() {
+			list = append(list, filepath.Join(dir, name))
+		}
+	}
+
+	return list, nil
+}
+
+// Problem is a problem found by CheckSDK in a goroot of the sdk directory.
+type Problem struct {
+	GoRoot string
+	Err    error
+}
+
+// String returns the problem as "goroot: error".
+func (p Problem) String() string {
+	return p.GoRoot + ": " + p.Err.Error()
+}
+
+// CheckSDK checks the goroots in the sdk directory dir with a name matching
+// layout, as described in Filter.Layout, and returns the releases and all the
+// problems found: a missing go command, a go version command that fails or a
+// version that can not be parsed.  Unlike DiscoverReleases, CheckSDK does not
+// stop at the first problem and does not use the VersionCache file.  An error
+// is returned only if the sdk directory can not be read.
+func CheckSDK(dir, layout string) ([]Release, []Problem, error) {
+	goroots, err := sdkgoroots(dir, layout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var releases []Release
+	var problems []Problem
+	for _, goroot := range goroots {
+		if _, err := os.Stat(gocommand(goroot)); err != nil {
+			problems = append(problems, Problem{goroot, err})
+
+			continue
+		}
+		line, err := goversion(goroot)
+		if err != nil {
+			problems = append(problems, Problem{goroot, errors.Unwrap(err)})
+
+			continue
+		}
+		rel, err := parserelease(goroot, line)
+		if err != nil {
+			problems = append(problems, Problem{goroot, err})
+
+			continue
+		}
+		releases = append(releases, rel)
+	}
+
+	return releases, problems, nil
+}
+
+// parserelease returns the release in goroot, given the line returned by go
+// version.
+func parserelease(goroot, line string) (Release, error) {
+	rel := Release{
+		GoRoot: goroot,
+		Devel:  strings.HasPrefix(line, "go version devel "),
+	}
+
+	v, err := version.ParseLine(line)
+	if err != nil {
+		if rel.Devel {
+			// Old development versions only report the commit hash, like
+			// "go version devel +3f4977bd58 <timestamp> <os>/<arch>".
+			return rel, nil
+		}
+
+		return rel, err
+	}
+	rel.Version = v
+
+	return rel, nil
+}
+
+// match returns true if rel is selected by f.  Development versions are
+// excluded when f.Stable or f.ExcludeDevel is set.  The Since filter is not
+// applied to development versions, while Constraint, Pattern, Glob and
+// Exclude are applied to the version they report, unless it is only a commit
+// hash.  A development version, like go1.23-abcdef, is excluded both by its
+// version and by its release, like go1.23.
+func (f Filter) match(rel Release) bool {
+	if rel.Devel {
+		if f.Stable || f.ExcludeDevel {
+			return false
+		}
+		if rel.Version == (version.Version{}) {
+			return true
+		}
+
+		if !f.Constraint.Matches(rel.Version) {
+			return false
+		}
+		if f.Pattern != nil && !f.Pattern.MatchString(rel.Version.String()) {
+			return false
+		}
+		if !f.Glob.Matches(rel.Version) {
+			return false
+		}
+		for _, v := range f.Exclude {
+			if rel.Version.Equal(v) || rel.Version.Release().Equal(v) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	since := rel.Version
+	if f.SincePreReleases {
+		since = since.Release()
+	}
+	if since.Less(f.Since) {
+		return false
+	}
+	if f.Stable && rel.Version.IsPreRelease() {
+		return false
+	}
+	if !f.Constraint.Matches(rel.Version) {
+		return false
+	}
+	if f.Pattern != nil && !f.Pattern.MatchString(rel.Version.String()) {
+		return false
+	}
+	if !f.Glob.Matches(rel.Version) {
+		return false
+	}
+	for _, v := range f.Exclude {
+		if rel.Version.Equal(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// latestpatch returns the most recent release of each minor version, from a
+// sorted list of releases.  Since a final release is more recent than its
+// own pre-releases, a pre-release is returned only if the final release is
+// not in the list.  Development versions are always returned.
+func latestpatch(list []Release) []Release {
+	result := make([]Release, 0, len(list))
+	for i, rel := range list {
+		if i+1 < len(list) && !rel.Devel {
+			next := list[i+1]
+			if !next.Devel && next.Version.CompareMinor(rel.Version) == 0 {
+				continue
+			}
+		}
+		result = append(result, rel)
+	}
+
+	return result
+}
@@ -0,0 +1,1107 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perillo/go-compatible/internal/fakesdk"
+	"github.com/perillo/go-compatible/version"
+)
+
+// TestRunCancel tests that Run returns promptly with a context error when the
+// context is canceled while a release is being processed.
+func TestRunCancel(t *testing.T) {
+	releases := []Release{
+		fakeRelease(t, "go1.16", "exec sleep 10"),
+		fakeRelease(t, "go1.17", "exec sleep 10"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := Run(ctx, releases, []string{"./..."}, Options{Mode: "vet"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected err to wrap %v, got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Run returned after %v", d)
+	}
+}
+
+// TestRuntoolTimeout tests that runtool reports a timeout distinctly from a
+// normal failure, without considering it a fatal error.
+func TestRuntoolTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	opts := Options{Mode: "vet", Timeout: timeout}
+
+	t.Run("timeout", func(t *testing.T) {
+		rel := fakeRelease(t, "go1.16", "exec sleep 10")
+		res, err := runtool(ctx, govet, rel, Platform{}, patterns, opts)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		validateResult(t, res, Fail, "timeout", "timeout: killed after 100ms")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		rel := fakeRelease(t, "go1.16", "echo 'vet: failure' >&2; exit 1")
+		res, err := runtool(ctx, govet, rel, Platform{}, patterns, opts)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		validateResult(t, res, Fail, "diagnostics found", "vet: failure")
+	})
+}
+
+// TestRuntoolRetries tests that runtool invokes the tool again when a release
+// fails, up to opts.Retries times.
+func TestRuntoolRetries(t *testing.T) {
+	defer func(d time.Duration) { retrydelay = d }(retrydelay)
+	retrydelay = time.Millisecond
+
+	ctx := context.Background()
+	patterns := []string{"./..."}
+
+	// The go command fails the first time it is invoked.
+	newrelease := func() Release {
+		marker := filepath.Join(t.TempDir(), "marker")
+		script := `if [ -e "` + marker + `" ]; then exit 0; fi
+touch "` + marker + `"
+echo FAIL; exit 1`
+
+		return fakeRelease(t, "go1.16", script)
+	}
+
+	res, err := runtool(ctx, gotest, newrelease(), Platform{}, patterns,
+		Options{Mode: "test"})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	validateResult(t, res, Fail, "diagnostics found", "FAIL")
+
+	res, err = runtool(ctx, gotest, newrelease(), Platform{}, patterns,
+		Options{Mode: "test", Retries: 2})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	validateResult(t, res, Pass, "", "")
+
+	// A missing go command is not retried.
+	rel := newrelease()
+	rel.GoRoot = filepath.Join(t.TempDir(), "missing")
+	_, err = runtool(ctx, gotest, rel, Platform{}, patterns,
+		Options{Mode: "test", Retries: 2})
+	if err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestStatus tests the status and reason reported by runtool for a mix of
+// passing, failing and skipped releases.
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	opts := Options{Mode: "test", Timeout: 100 * time.Millisecond, Race: true}
+	plat := Platform{"linux", "amd64"} // supports the race detector
+
+	var tests = []struct {
+		rel    Release
+		status Status
+		reason string
+	}{
+		{fakeRelease(t, "go1.0", "exit 0"), Skip, "race detector not supported"},
+		{fakeRelease(t, "go1.15", "echo 'FAIL' >&2; exit 1"), Fail, "diagnostics found"},
+		{fakeRelease(t, "go1.16", "exec sleep 10"), Fail, "timeout"},
+		{fakeRelease(t, "go1.17", "exit 0"), Pass, ""},
+	}
+	for _, test := range tests {
+		res, err := runtool(ctx, gotest, test.rel, plat, patterns, opts)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.rel, err)
+		}
+		if res.Status != test.status {
+			t.Errorf("%s: want res.Status = %v, got %v", test.rel, test.status,
+				res.Status)
+		}
+		if res.Reason != test.reason {
+			t.Errorf("%s: want res.Reason = %q, got %q", test.rel, test.reason,
+				res.Reason)
+		}
+	}
+}
+
+// TestRun tests that Run returns the result of each release and platform.
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	opts := Options{
+		Mode:      "test",
+		Platforms: []Platform{{"linux", "amd64"}, {"linux", "arm64"}},
+	}
+
+	releases := []Release{
+		fakeRelease(t, "go1.16", "exit 0"),
+		fakeRelease(t, "go1.17", "echo FAIL; exit 1"),
+	}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	var tests = []struct {
+		rel    string
+		plat   Platform
+		status Status
+		msg    string
+	}{
+		{"go1.16", Platform{"linux", "amd64"}, Pass, ""},
+		{"go1.16", Platform{"linux", "arm64"}, Pass, ""},
+		{"go1.17", Platform{"linux", "amd64"}, Fail, "FAIL"},
+		{"go1.17", Platform{"linux", "arm64"}, Fail, "FAIL"},
+	}
+	if len(results) != len(tests) {
+		t.Fatalf("want %d results, got %d", len(tests), len(results))
+	}
+	for i, test := range tests {
+		res := results[i]
+		if s := res.Release.String(); s != test.rel {
+			t.Errorf("%d: want res.Release = %s, got %s", i, test.rel, s)
+		}
+		if res.Platform != test.plat {
+			t.Errorf("%d: want res.Platform = %s, got %s", i, test.plat, res.Platform)
+		}
+		if res.Tool != "test" {
+			t.Errorf("%d: want res.Tool = %q, got %q", i, "test", res.Tool)
+		}
+		if res.Status != test.status {
+			t.Errorf("%d: want res.Status = %v, got %v", i, test.status,
+				res.Status)
+		}
+		if string(res.Msg) != test.msg {
+			t.Errorf("%d: want res.Msg = %q, got %q", i, test.msg, res.Msg)
+		}
+	}
+}
+
+// TestProgress tests that the started event is reported before invoking the
+// tool for each release and platform.
+func TestProgress(t *testing.T) {
+	var calls []string
+	opts := Options{
+		Mode:      "test",
+		Platforms: []Platform{{"linux", "amd64"}, {"linux", "arm64"}},
+		Report: func(ev Event) {
+			if ev.Kind == EventStarted {
+				calls = append(calls, fmt.Sprintf("%d/%d %s %s", ev.N, ev.Total,
+					ev.Release, ev.Platform))
+			}
+		},
+	}
+	releases := []Release{
+		fakeRelease(t, "go1.16", "exit 0"),
+		fakeRelease(t, "go1.17", "exit 0"),
+	}
+	if _, err := Run(context.Background(), releases, []string{"./..."}, opts); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	want := []string{
+		"1/4 go1.16 linux/amd64",
+		"2/4 go1.16 linux/arm64",
+		"3/4 go1.17 linux/amd64",
+		"4/4 go1.17 linux/arm64",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("want calls = %q, got %q", want, calls)
+	}
+}
+
+// TestReport tests the sequence of events reported by Run, with a diagnostic
+// event only for the release that reports a diagnostic message.
+func TestReport(t *testing.T) {
+	var events []string
+	opts := Options{
+		Mode: "vet",
+		Report: func(ev Event) {
+			s := fmt.Sprintf("%s %d/%d %s", ev.Kind, ev.N, ev.Total, ev.Release)
+			if ev.Kind != EventStarted {
+				s += " " + ev.Result.Status.String()
+			}
+			events = append(events, s)
+		},
+	}
+	releases := []Release{
+		fakeRelease(t, "go1.16", "echo 'vet: failure' >&2; exit 1"),
+		fakeRelease(t, "go1.17", "exit 0"),
+	}
+	if _, err := Run(context.Background(), releases, []string{"./..."}, opts); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	want := []string{
+		"started 1/2 go1.16",
+		"diagnostic 1/2 go1.16 FAIL",
+		"finished 1/2 go1.16 FAIL",
+		"started 2/2 go1.17",
+		"finished 2/2 go1.17 PASS",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("want events = %q, got %q", want, events)
+	}
+}
+
+// TestIgnore tests that the lines matching Options.Ignore are removed from the
+// diagnostic message, and that a release with only ignored lines passes.
+func TestIgnore(t *testing.T) {
+	re := regexp.MustCompile(`is deprecated`)
+	var tests = []struct {
+		msg  string
+		want string
+	}{
+		{"# example.com/pkg\n./pkg.go:3:2: ioutil is deprecated", ""},
+		{"# example.com/a\n./a.go:1:1: x is deprecated\n# example.com/b\n./b.go:2:2: bad format",
+			"# example.com/b\n./b.go:2:2: bad format"},
+		{"./pkg.go:3:2: bad format\n./pkg.go:4:2: y is deprecated", "./pkg.go:3:2: bad format"},
+		{"./pkg.go:3:2: bad format", "./pkg.go:3:2: bad format"},
+	}
+	for _, test := range tests {
+		if s := string(ignorelines([]byte(test.msg), re)); s != test.want {
+			t.Errorf("%q: want msg = %q, got %q", test.msg, test.want, s)
+		}
+	}
+
+	releases := []Release{
+		fakeRelease(t, "go1.16", "echo './pkg.go:3:2: ioutil is deprecated' >&2; exit 1"),
+		fakeRelease(t, "go1.17", "printf './a.go:1:1: x is deprecated\\n./a.go:2:2: bad format\\n' >&2; exit 1"),
+	}
+	opts := Options{Mode: "vet", Ignore: re}
+	results, err := Run(context.Background(), releases, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	validateResult(t, results[0], Pass, "", "")
+	validateResult(t, results[1], Fail, "diagnostics found", "./a.go:2:2: bad format")
+}
+
+// TestCount tests that the results are counted by status.
+func TestCount(t *testing.T) {
+	results := []Result{
+		{Status: Skip}, {Status: Fail}, {Status: Pass}, {Status: Fail},
+		{Status: Pass}, {Status: Pass},
+	}
+	want := Counts{Total: 6, Passed: 3, Failed: 2, Skipped: 1}
+	if c := Count(results); c != want {
+		t.Errorf("want counts = %+v, got %+v", want, c)
+	}
+	if c := Count(nil); c != (Counts{}) {
+		t.Errorf("want counts = %+v, got %+v", Counts{}, c)
+	}
+}
+
+// TestFirstFail tests that Run stops after the first failed release when
+// opts.FirstFail is set.
+func TestFirstFail(t *testing.T) {
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	opts := Options{Mode: "test", FirstFail: true}
+
+	releases := []Release{
+		fakeRelease(t, "go1.16", "exit 0"),
+		fakeRelease(t, "go1.17", "echo FAIL; exit 1"),
+		fakeRelease(t, "go1.18", "echo FAIL; exit 1"),
+	}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 results, got %d", len(results))
+	}
+	validateResult(t, results[0], Pass, "", "")
+	validateResult(t, results[1], Fail, "diagnostics found", "FAIL")
+}
+
+// TestKeepGoing tests that a release with a fatal error is skipped when
+// opts.KeepGoing is set, and that the other releases are processed.
+func TestKeepGoing(t *testing.T) {
+	ctx := context.Background()
+	patterns := []string{"./..."}
+
+	broken := fakeRelease(t, "go1.17", "exit 0")
+	broken.GoRoot = filepath.Join(t.TempDir(), "missing")
+	releases := []Release{
+		fakeRelease(t, "go1.16", "exit 0"),
+		broken,
+		fakeRelease(t, "go1.18", "echo FAIL; exit 1"),
+	}
+
+	if _, err := Run(ctx, releases, patterns, Options{Mode: "test"}); err == nil {
+		t.Error("expected err != nil")
+	}
+
+	opts := Options{Mode: "test", KeepGoing: true}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(results))
+	}
+	validateResult(t, results[0], Pass, "", "")
+	validateResult(t, results[1], Skip, "fatal error", "")
+	validateResult(t, results[2], Fail, "diagnostics found", "FAIL")
+}
+
+// TestDuration tests that the duration of the tool invocation is recorded.
+func TestDuration(t *testing.T) {
+	rel := fakeRelease(t, "go1.16", "sleep 0.1; exit 0")
+	res, err := runtool(context.Background(), gotest, rel, Platform{},
+		[]string{"./..."}, Options{Mode: "test"})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if res.Duration < 100*time.Millisecond {
+		t.Errorf("want res.Duration >= 100ms, got %v", res.Duration)
+	}
+}
+
+// validateResult validates the result returned by runtool.
+func validateResult(t *testing.T, res Result, status Status, reason, msg string) {
+	if res.Status != status {
+		t.Errorf("want res.Status = %v, got %v", status, res.Status)
+	}
+	if res.Reason != reason {
+		t.Errorf("want res.Reason = %q, got %q", reason, res.Reason)
+	}
+	if string(res.Msg) != msg {
+		t.Errorf("want res.Msg = %q, got %q", msg, res.Msg)
+	}
+}
+
+// TestPlatforms tests that the Platforms function returns the cross product of
+// the operating systems and architectures.
+func TestPlatforms(t *testing.T) {
+	var tests = []struct {
+		goos   string
+		goarch string
+		want   []Platform
+	}{
+		{"", "", nil},
+		{"linux", "", []Platform{{"linux", ""}}},
+		{"", "arm64", []Platform{{"", "arm64"}}},
+		{"linux,windows", "amd64,arm64", []Platform{
+			{"linux", "amd64"}, {"linux", "arm64"},
+			{"windows", "amd64"}, {"windows", "arm64"},
+		}},
+	}
+	for _, test := range tests {
+		got := Platforms(test.goos, test.goarch)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Platforms(%q, %q): want %v, got %v", test.goos,
+				test.goarch, test.want, got)
+		}
+	}
+}
+
+// TestDiscoverReleases tests that the releases found in a fake sdk are
+// returned in order, respecting the since version.
+func TestDiscoverReleases(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.9", "go1.15.2", "go1.17beta1")
+
+	releases, err := DiscoverReleases(sdk, Filter{
+		Since: version.Must(version.Parse("go1.15")),
+	})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.15.2", "go1.16", "go1.17beta1"})
+	for _, rel := range releases {
+		if want := filepath.Join(sdk, rel.String()); rel.GoRoot != want {
+			t.Errorf("%s: want rel.GoRoot = %q, got %q", rel, want, rel.GoRoot)
+		}
+	}
+}
+
+// TestLatest tests that only the most recent releases are selected when
+// Filter.Latest is > 0.
+func TestLatest(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.9", "go1.15.2", "go1.17beta1", "go1.14")
+
+	var tests = []struct {
+		since  string
+		latest int
+		want   []string
+	}{
+		{"", 2, []string{"go1.16", "go1.17beta1"}},
+		{"", 0, []string{"go1.9", "go1.14", "go1.15.2", "go1.16", "go1.17beta1"}},
+		{"", -1, []string{"go1.9", "go1.14", "go1.15.2", "go1.16", "go1.17beta1"}},
+		{"", 10, []string{"go1.9", "go1.14", "go1.15.2", "go1.16", "go1.17beta1"}},
+		{"go1.15", 2, []string{"go1.16", "go1.17beta1"}},
+		{"go1.17beta1", 2, []string{"go1.17beta1"}},
+	}
+	for _, test := range tests {
+		var f Filter
+		if test.since != "" {
+			f.Since = version.Must(version.Parse(test.since))
+		}
+		f.Latest = test.latest
+
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestMinorOnly tests that only the most recent release of each minor
+// version is selected when Filter.Minor is set.
+func TestMinorOnly(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20.1", "go1.20.2", "go1.20", "go1.21rc2",
+		"go1.21.3", "go1.21.0", "go1.22beta1", "go1.22rc1", "go1.19")
+
+	var tests = []struct {
+		latest int
+		want   []string
+	}{
+		{0, []string{"go1.19", "go1.20.2", "go1.21.3", "go1.22rc1"}},
+		{2, []string{"go1.21.3", "go1.22rc1"}},
+	}
+	for _, test := range tests {
+		f := Filter{
+			Latest: test.latest,
+			Minor:  true,
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestMatch tests that only the releases with a version matching the regular
+// expression are selected when Filter.Pattern is set.
+func TestMatch(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.20.1", "go1.21rc2", "go1.21.0",
+		"go1.21.3", "go1.22.0", "go1.19")
+
+	var tests = []struct {
+		pattern string
+		want    []string
+	}{
+		{`^1\.21`, []string{"go1.21rc2", "go1.21.0", "go1.21.3"}},
+		{`\.0$`, []string{"go1.21.0", "go1.22.0"}},
+		{`^1\.2[0-9]\.[1-9]`, []string{"go1.20.1", "go1.21.3"}},
+	}
+	for _, test := range tests {
+		f := Filter{Pattern: regexp.MustCompile(test.pattern)}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestMatchDevel tests that Filter.Pattern is matched against the version
+// reported by a development version, and that a development version only
+// reporting the commit hash is always selected.
+func TestMatchDevel(t *testing.T) {
+	var tests = []struct {
+		line    string
+		pattern string
+		want    []string
+	}{
+		{
+			"go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			`^1\.21`,
+			[]string{"go1.21.0", "go1.21.1"},
+		},
+		{
+			"go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			`^1\.2[13]`,
+			[]string{"go1.21.0", "go1.21.1", "devel go1.23-abcdef"},
+		},
+		{
+			"go version devel +abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			`^1\.21`,
+			[]string{"go1.21.0", "go1.21.1", "devel"},
+		},
+	}
+	for _, test := range tests {
+		sdk := fakesdk.SDK(t, "go1.20", "go1.21.0", "go1.21.1")
+		fakesdk.Goroot(t, filepath.Join(sdk, "gotip"), "echo "+test.line)
+
+		f := Filter{Pattern: regexp.MustCompile(test.pattern)}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestGlob tests that only the releases matching the glob pattern are selected
+// when Filter.Glob is set, and that the pattern composes with Filter.Since.
+func TestGlob(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.19", "go1.20", "go1.20.1", "go1.21rc2", "go1.21.0",
+		"go1.21.3", "go1.22.0")
+
+	var tests = []struct {
+		pattern string
+		since   string
+		want    []string
+	}{
+		{"1.20.*", "", []string{"go1.20", "go1.20.1"}},
+		{"1.2?", "", []string{"go1.20", "go1.20.1", "go1.21rc2", "go1.21.0",
+			"go1.21.3", "go1.22.0"}},
+		{"1.2?", "go1.21.0", []string{"go1.21.0", "go1.21.3", "go1.22.0"}},
+		{"1.*.0", "go1.20", []string{"go1.20", "go1.21.0", "go1.22.0"}},
+	}
+	for _, test := range tests {
+		f := Filter{Glob: mustglob(test.pattern)}
+		if test.since != "" {
+			f.Since = version.Must(version.Parse(test.since))
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestGlobDevel tests that Filter.Glob is matched against the version
+// reported by a development version.
+func TestGlobDevel(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.21.0", "go1.21.1")
+	line := "go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64"
+	fakesdk.Goroot(t, filepath.Join(sdk, "gotip"), "echo "+line)
+
+	var tests = []struct {
+		pattern string
+		want    []string
+	}{
+		{"1.21.*", []string{"go1.21.0", "go1.21.1"}},
+		{"1.2?", []string{"go1.20", "go1.21.0", "go1.21.1",
+			"devel go1.23-abcdef"}},
+		{"1.23.*", []string{"devel go1.23-abcdef"}},
+	}
+	for _, test := range tests {
+		f := Filter{Glob: mustglob(test.pattern)}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestExcludeDevel tests that development versions are excluded by
+// Filter.ExcludeDevel and by the release of the version they report.
+func TestExcludeDevel(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.21.0", "go1.22.0")
+	line := "go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64"
+	fakesdk.Goroot(t, filepath.Join(sdk, "gotip"), "echo "+line)
+
+	var tests = []struct {
+		exclude []string
+		devel   bool
+		want    []string
+	}{
+		{nil, false, []string{"go1.21.0", "go1.22.0", "devel go1.23-abcdef"}},
+		{nil, true, []string{"go1.21.0", "go1.22.0"}},
+		{[]string{"go1.23"}, false, []string{"go1.21.0", "go1.22.0"}},
+		{[]string{"go1.23-abcdef"}, false, []string{"go1.21.0", "go1.22.0"}},
+		{[]string{"go1.22.0"}, false, []string{"go1.21.0", "devel go1.23-abcdef"}},
+	}
+	for _, test := range tests {
+		f := Filter{ExcludeDevel: test.devel}
+		for _, s := range test.exclude {
+			f.Exclude = append(f.Exclude, version.Must(version.Parse(s)))
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.exclude, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// mustglob is like version.ParseGlob but panics if the pattern can not be
+// parsed.
+func mustglob(s string) version.Glob {
+	g, err := version.ParseGlob(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return g
+}
+
+// TestDescending tests that the releases are sorted from the most recent when
+// Filter.Descending is set, and that the other filters still apply.
+func TestDescending(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.17", "go1.17.1", "go1.18rc1", "go1.18",
+		"go1.19")
+
+	var tests = []struct {
+		name string
+		f    Filter
+		want []string
+	}{
+		{"all", Filter{}, []string{"go1.19", "go1.18", "go1.18rc1", "go1.17.1",
+			"go1.17", "go1.16"}},
+		{"latest", Filter{Latest: 2}, []string{"go1.19", "go1.18"}},
+		{"minor", Filter{Minor: true, Since: version.Must(version.Parse("go1.17"))},
+			[]string{"go1.19", "go1.18", "go1.17.1"}},
+		{"stable", Filter{Stable: true, Latest: 3}, []string{"go1.19", "go1.18",
+			"go1.17.1"}},
+	}
+	for _, test := range tests {
+		test.f.Descending = true
+		releases, err := DiscoverReleases(sdk, test.f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.name, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestVersionTimeout tests that a go command hanging on go version does not
+// block the discovery of the other releases.
+func TestVersionTimeout(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.21")
+	fakesdk.Goroot(t, filepath.Join(sdk, "go1.19"), "exec sleep 60")
+
+	defer func(d time.Duration) {
+		VersionTimeout = d
+	}(VersionTimeout)
+	VersionTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	releases, err := DiscoverReleases(sdk, Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("want discovery to complete within 10s, got %v", d)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.20", "go1.21.0"})
+}
+
+// TestSincePreReleases tests that the pre-releases of Filter.Since are
+// selected only when Filter.SincePreReleases is set.
+func TestSincePreReleases(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.20.5", "go1.21beta1", "go1.21rc1",
+		"go1.21rc2", "go1.21.0", "go1.21.3", "go1.22rc1")
+
+	var tests = []struct {
+		since string
+		pre   bool
+		want  []string
+	}{
+		{"go1.21", false, []string{"go1.21.0", "go1.21.3", "go1.22rc1"}},
+		{"go1.21", true, []string{"go1.21beta1", "go1.21rc1", "go1.21rc2",
+			"go1.21.0", "go1.21.3", "go1.22rc1"}},
+		{"go1.21rc2", false, []string{"go1.21rc2", "go1.21.0", "go1.21.3",
+			"go1.22rc1"}},
+		{"go1.21.3", true, []string{"go1.21.3", "go1.22rc1"}},
+		{"go1.20.5", true, []string{"go1.20.5", "go1.21beta1", "go1.21rc1",
+			"go1.21rc2", "go1.21.0", "go1.21.3", "go1.22rc1"}},
+	}
+	for _, test := range tests {
+		f := Filter{
+			Since:            version.Must(version.Parse(test.since)),
+			SincePreReleases: test.pre,
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.since, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestExclude tests that the releases with an excluded version are removed,
+// and that the exclusion composes with the other filters.
+func TestExclude(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.19", "go1.20", "go1.20.1", "go1.20.2", "go1.21.0")
+
+	var tests = []struct {
+		f    Filter
+		want []string
+	}{
+		{
+			Filter{Exclude: []version.Version{version.Must(version.Parse("go1.20.1"))}},
+			[]string{"go1.19", "go1.20", "go1.20.2", "go1.21.0"},
+		},
+		{
+			Filter{
+				Since: version.Must(version.Parse("go1.20")),
+				Exclude: []version.Version{
+					version.Must(version.Parse("go1.20.2")),
+					version.Must(version.Parse("go1.21.0")),
+					version.Must(version.Parse("go1.22.0")),
+				},
+			},
+			[]string{"go1.20", "go1.20.1"},
+		},
+		{
+			Filter{
+				Minor:   true,
+				Exclude: []version.Version{version.Must(version.Parse("go1.20.2"))},
+			},
+			[]string{"go1.19", "go1.20.1", "go1.21.0"},
+		},
+	}
+	for _, test := range tests {
+		releases, err := DiscoverReleases(sdk, test.f)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// TestLayout tests that only the goroot directories with a name matching
+// Filter.Layout are discovered.
+func TestLayout(t *testing.T) {
+	sdk := t.TempDir()
+	for name, goversion := range map[string]string{
+		"go-1.21.3":  "go1.21.3",
+		"go-1.20":    "go1.20",
+		"golang1.19": "go1.19",
+		"go1.18":     "go1.18",
+	} {
+		script := "echo go version " + goversion + " linux/amd64"
+		fakesdk.Goroot(t, filepath.Join(sdk, name), script)
+	}
+
+	var tests = []struct {
+		layout string
+		want   []string
+	}{
+		{"", []string{"go1.18", "go1.19", "go1.20", "go1.21.3"}},
+		{"go-*", []string{"go1.20", "go1.21.3"}},
+		{"golang1.?*", []string{"go1.19"}},
+		{"go1.*", []string{"go1.18"}},
+	}
+	for _, test := range tests {
+		releases, err := DiscoverReleases(sdk, Filter{Layout: test.layout})
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.layout, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+
+	if _, err := DiscoverReleases(sdk, Filter{Layout: "sdk-*"}); err == nil {
+		t.Error("expected err != nil")
+	}
+	if _, err := DiscoverReleases(sdk, Filter{Layout: "go["}); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestVersionCache tests that a warm version cache avoids invoking go version
+// again, and that an entry is invalidated when the go command changes.
+func TestVersionCache(t *testing.T) {
+	defer func(file string) { VersionCache = file }(VersionCache)
+
+	// The go command counts its invocations.
+	counter := filepath.Join(t.TempDir(), "counter")
+	script := func(goversion string) string {
+		return "echo x >> " + counter + "\n" +
+			"echo go version " + goversion + " linux/amd64"
+	}
+	count := func() int {
+		data, err := os.ReadFile(counter)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		return bytes.Count(data, []byte("x"))
+	}
+	discover := func(sdk string, want ...string) {
+		t.Helper()
+		releases, err := DiscoverReleases(sdk, Filter{})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, want)
+	}
+
+	sdk := t.TempDir()
+	fakesdk.Goroot(t, filepath.Join(sdk, "go1.16"), script("go1.16"))
+	fakesdk.Goroot(t, filepath.Join(sdk, "go1.17"), script("go1.17"))
+	VersionCache = filepath.Join(t.TempDir(), "cache", "versions.json")
+
+	discover(sdk, "go1.16", "go1.17")
+	if n := count(); n != 2 {
+		t.Errorf("cold cache: want 2 invocations, got %d", n)
+	}
+	discover(sdk, "go1.16", "go1.17")
+	if n := count(); n != 2 {
+		t.Errorf("warm cache: want 2 invocations, got %d", n)
+	}
+
+	// Replace the go1.17 goroot with go1.17.1.
+	fakesdk.Goroot(t, filepath.Join(sdk, "go1.17"), script("go1.17.1"))
+	discover(sdk, "go1.16", "go1.17.1")
+	if n := count(); n != 3 {
+		t.Errorf("changed command: want 3 invocations, got %d", n)
+	}
+
+	// The cache is disabled.
+	VersionCache = ""
+	discover(sdk, "go1.16", "go1.17.1")
+	if n := count(); n != 5 {
+		t.Errorf("disabled cache: want 5 invocations, got %d", n)
+	}
+}
+
+// TestCheckSDK tests that CheckSDK reports all the problems in an sdk
+// directory mixing valid goroots, goroots without the go command and goroots
+// with a version that can not be parsed.
+func TestCheckSDK(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.17")
+	fakesdk.Goroot(t, filepath.Join(sdk, "gobad"), "echo go version bad linux/amd64")
+	fakesdk.Goroot(t, filepath.Join(sdk, "gofail"), "echo broken >&2; exit 1")
+	if err := os.MkdirAll(filepath.Join(sdk, "gomissing", "bin"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(sdk, "other"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	releases, problems, err := CheckSDK(sdk, "")
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.16", "go1.17"})
+	var tests = []struct {
+		goroot string
+		want   string
+	}{
+		{"gobad", "parse:"},
+		{"gofail", "broken"},
+		{"gomissing", "no such file or directory"},
+	}
+	if len(problems) != len(tests) {
+		t.Fatalf("want %d problems, got %q", len(tests), problems)
+	}
+	for i, test := range tests {
+		p := problems[i]
+		if goroot := filepath.Join(sdk, test.goroot); p.GoRoot != goroot {
+			t.Errorf("want p.GoRoot = %q, got %q", goroot, p.GoRoot)
+		}
+		if !strings.Contains(p.Err.Error(), test.want) {
+			t.Errorf("%s: want error containing %q, got %q", test.goroot,
+				test.want, p.Err)
+		}
+	}
+
+	if _, _, err := CheckSDK(filepath.Join(sdk, "none"), ""); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestUnparsableVersion tests that a goroot with an unparsable version line
+// is skipped, and that the other releases are discovered.
+func TestUnparsableVersion(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.21.0")
+	fakesdk.Goroot(t, filepath.Join(sdk, "gobroken"), "echo hello")
+	fakesdk.Goroot(t, filepath.Join(sdk, "goempty"), "exit 0")
+
+	releases, err := DiscoverReleases(sdk, Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.20", "go1.21.0"})
+}
+
+// TestNoReleases tests that DiscoverReleases reports distinct errors for a missing
+// sdk, an empty sdk and an sdk where all the releases are filtered out.
+func TestNoReleases(t *testing.T) {
+	f := Filter{Since: version.Must(version.Parse("go1.18"))}
+	empty := t.TempDir()
+	old := fakesdk.SDK(t, "go1.16", "go1.17")
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	var tests = []struct {
+		name string
+		sdk  string
+		want string
+	}{
+		{"missing", missing, "sdk directory " + missing + " does not exist"},
+		{"empty", empty, "no go releases found in " + empty},
+		{"filtered", old, "no go releases in " + old + " match the filter " +
+			"(2 excluded)"},
+	}
+	for _, test := range tests {
+		_, err := DiscoverReleases(test.sdk, f)
+		if err == nil {
+			t.Fatalf("%s: expected err != nil", test.name)
+		}
+		if s := err.Error(); s != test.want {
+			t.Errorf("%s: want err = %q, got %q", test.name, test.want, s)
+		}
+	}
+}
+
+// TestDuplicates tests that only the first of two goroots reporting the same
+// release is used, and that duplicates are an error when
+// Filter.FailDuplicates is set.
+func TestDuplicates(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.21.0")
+	dup := filepath.Join(sdk, "goX")
+	fakesdk.Goroot(t, dup, "echo go version go1.21.0 linux/amd64")
+
+	releases, err := DiscoverReleases(sdk, Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.20", "go1.21.0"})
+	if want := filepath.Join(sdk, "go1.21.0"); releases[1].GoRoot != want {
+		t.Errorf("want goroot = %q, got %q", want, releases[1].GoRoot)
+	}
+
+	_, err = DiscoverReleases(sdk, Filter{FailDuplicates: true})
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	want := "duplicate release go1.21.0 in " + filepath.Join(sdk, "go1.21.0") +
+		" and " + dup
+	if s := err.Error(); s != want {
+		t.Errorf("want err = %q, got %q", want, s)
+	}
+}
+
+// TestStable tests that pre-releases are excluded when Filter.Stable is set.
+func TestStable(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.20", "go1.21rc2", "go1.21.0", "go1.22beta1",
+		"go1.19")
+
+	f := Filter{
+		Since:  version.Must(version.Parse("go1.20")),
+		Stable: true,
+	}
+	releases, err := DiscoverReleases(sdk, f)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.20", "go1.21.0"})
+}
+
+// TestDevel tests that development versions, like gotip, are discovered and
+// ordered after all the releases.
+func TestDevel(t *testing.T) {
+	var tests = []struct {
+		line string
+		want string
+	}{
+		{
+			"go version devel go1.18-3f4977bd58 Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			"devel go1.18-3f4977bd58",
+		},
+		{
+			"go version devel +3f4977bd58 Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			"devel",
+		},
+	}
+	for _, test := range tests {
+		sdk := fakesdk.SDK(t, "go1.18", "go1.17", "go1.19beta1")
+		fakesdk.Goroot(t, filepath.Join(sdk, "gotip"), "echo "+test.line)
+
+		f := Filter{
+			Since: version.Must(version.Parse("go1.17")),
+			Minor: true,
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, []string{
+			"go1.17", "go1.18", "go1.19beta1", test.want,
+		})
+
+		f = Filter{Stable: true}
+		releases, err = DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		fakesdk.ValidateReleases(t, releases, []string{"go1.17", "go1.18"})
+	}
+}
+
+// TestConstraintDevel tests that Filter.Constraint is applied to the version
+// reported by a development version, and that a development version only
+// reporting the commit hash is always selected.
+func TestConstraintDevel(t *testing.T) {
+	var tests = []struct {
+		line       string
+		constraint string
+		want       []string
+	}{
+		{
+			"go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			"<1.22",
+			[]string{"go1.21.0"},
+		},
+		{
+			"go version devel go1.23-abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			">=1.22",
+			[]string{"go1.22.0", "devel go1.23-abcdef"},
+		},
+		{
+			"go version devel +abcdef Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			"<1.22",
+			[]string{"go1.21.0", "devel"},
+		},
+	}
+	for _, test := range tests {
+		sdk := fakesdk.SDK(t, "go1.21.0", "go1.22.0")
+		fakesdk.Goroot(t, filepath.Join(sdk, "gotip"), "echo "+test.line)
+
+		var f Filter
+		if err := f.Constraint.Set(test.constraint); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.constraint, err)
+		}
+		releases, err := DiscoverReleases(sdk, f)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.constraint, err)
+		}
+		fakesdk.ValidateReleases(t, releases, test.want)
+	}
+}
+
+// fakeRelease creates a fake goroot with a go command implemented by the
+// specified shell script, and returns the corresponding release.  As with
+// fakesdk.Goroot, the test is skipped on Windows.
+func fakeRelease(t *testing.T, goversion, script string) Release {
+	goroot := filepath.Join(t.TempDir(), goversion)
+	fakesdk.Goroot(t, goroot, script)
+
+	return Release{
+		GoRoot:  goroot,
+		Version: version.Must(version.Parse(goversion)),
+	}
+}
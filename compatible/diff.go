@@ -0,0 +1,60 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import "github.com/perillo/go-compatible/version"
+
+// Change is a change of status between the results of the same version and
+// platform, from two different sdk directories.
+type Change struct {
+	Old Result
+	New Result
+}
+
+// Regression returns true if the release passed in the old sdk directory and
+// failed in the new one.
+func (c Change) Regression() bool {
+	return c.Old.Status == Pass && c.New.Status == Fail
+}
+
+// Fix returns true if the release failed in the old sdk directory and passed
+// in the new one.
+func (c Change) Fix() bool {
+	return c.Old.Status == Fail && c.New.Status == Pass
+}
+
+// Diff compares the results from two sdk directories, matching the results
+// by version and platform, and returns the changes of status, in the order of
+// the new results.  The results of development versions and the results
+// without a match are ignored.
+func Diff(old, new []Result) []Change {
+	// A Version is already canonical: Equal versions are equal structs.
+	type key struct {
+		v    version.Version
+		plat Platform
+	}
+
+	index := make(map[key]Result, len(old))
+	for _, res := range old {
+		if res.Release.Devel {
+			continue
+		}
+		index[key{res.Release.Version, res.Platform}] = res
+	}
+
+	var changes []Change
+	for _, res := range new {
+		if res.Release.Devel {
+			continue
+		}
+		prev, ok := index[key{res.Release.Version, res.Platform}]
+		if !ok || prev.Status == res.Status {
+			continue
+		}
+		changes = append(changes, Change{Old: prev, New: res})
+	}
+
+	return changes
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"context"
+	"testing"
+)
+
+// TestDiff tests that Diff reports the releases that changed status between
+// two sdk directories, ignoring the releases only in one of them.
+func TestDiff(t *testing.T) {
+	old := []Release{
+		fakeRelease(t, "go1.19", "exit 0"),
+		fakeRelease(t, "go1.20", "exit 0"),
+		fakeRelease(t, "go1.21.0", "echo FAIL; exit 1"),
+		fakeRelease(t, "go1.22.0", "exit 0"),
+	}
+	new := []Release{
+		fakeRelease(t, "go1.20", "echo FAIL; exit 1"),
+		fakeRelease(t, "go1.21.0", "exit 0"),
+		fakeRelease(t, "go1.22.0", "exit 0"),
+		fakeRelease(t, "go1.23.0", "echo FAIL; exit 1"),
+	}
+	opts := Options{Mode: "test"}
+	ctx := context.Background()
+	oldres, err := Run(ctx, old, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	newres, err := Run(ctx, new, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	changes := Diff(oldres, newres)
+	if len(changes) != 2 {
+		t.Fatalf("want 2 changes, got %d", len(changes))
+	}
+
+	var tests = []struct {
+		goversion  string
+		regression bool
+		fix        bool
+	}{
+		{"go1.20", true, false},
+		{"go1.21.0", false, true},
+	}
+	for i, test := range tests {
+		c := changes[i]
+		if s := c.New.Release.String(); s != test.goversion {
+			t.Errorf("want changes[%d] = %s, got %s", i, test.goversion, s)
+		}
+		if c.Old.Release.GoRoot == c.New.Release.GoRoot {
+			t.Errorf("%s: want results from different goroots", test.goversion)
+		}
+		if r := c.Regression(); r != test.regression {
+			t.Errorf("%s: want regression = %t, got %t", test.goversion,
+				test.regression, r)
+		}
+		if f := c.Fix(); f != test.fix {
+			t.Errorf("%s: want fix = %t, got %t", test.goversion, test.fix, f)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/perillo/go-compatible/version"
+)
+
+// Module contains the versions declared in a go.mod file.  A version is the
+// zero Version when the directive is missing.
+type Module struct {
+	Go        version.Version // the language version, from the go directive
+	Toolchain version.Version // the toolchain version, from the toolchain directive
+}
+
+// ReadModule reads the go and toolchain directives in the go.mod file.
+func ReadModule(file string) (Module, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Module{}, err
+	}
+	defer f.Close()
+
+	mod, err := parsemodule(f)
+	if err != nil {
+		return Module{}, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return mod, nil
+}
+
+// parsemodule parses the go and toolchain directives in the go.mod file read
+// from r.  The other directives are ignored.
+//
+// This is not a go.mod parser; it only supports the go and toolchain
+// directives written on a single line, as done by the go command.
+func parsemodule(r io.Reader) (Module, error) {
+	var mod Module
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (fields[0] != "go" && fields[0] != "toolchain") {
+			continue
+		}
+		if len(fields) != 2 {
+			return Module{}, fmt.Errorf("line %d: usage: %s version", n, fields[0])
+		}
+
+		var err error
+		switch fields[0] {
+		case "go":
+			mod.Go, err = version.ParseLang(fields[1])
+		case "toolchain":
+			mod.Toolchain, err = version.ParseToolchain(fields[1])
+		}
+		if err != nil {
+			return Module{}, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+
+	return mod, sc.Err()
+}
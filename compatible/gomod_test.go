@@ -0,0 +1,69 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/perillo/go-compatible/version"
+)
+
+// TestReadModule tests that the go and toolchain directives are read from
+// go.mod files containing both directives or only one.
+func TestReadModule(t *testing.T) {
+	var tests = []struct {
+		name      string
+		gomod     string
+		goversion string
+		toolchain string
+	}{
+		{"both", "module example.com/m\n\ngo 1.21\n\ntoolchain go1.21.5\n",
+			"1.21.0", "1.21.5"},
+		{"go", "module example.com/m\n\ngo 1.16\n\nrequire example.com/x v1.0.0\n",
+			"1.16", ""},
+		{"toolchain", "module example.com/m\ntoolchain go1.22rc1 // pinned\n",
+			"", "1.22rc1"},
+		{"comment", "module example.com/m\n// go 1.18\ngo 1.20 // language\n",
+			"1.20", ""},
+		{"none", "module example.com/m\n", "", ""},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "go.mod")
+		if err := os.WriteFile(file, []byte(test.gomod), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mod, err := ReadModule(file)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.name, err)
+		}
+		if v := optversion(test.goversion); mod.Go != v {
+			t.Errorf("%s: want mod.Go = %v, got %v", test.name, v, mod.Go)
+		}
+		if v := optversion(test.toolchain); mod.Toolchain != v {
+			t.Errorf("%s: want mod.Toolchain = %v, got %v", test.name, v,
+				mod.Toolchain)
+		}
+	}
+
+	for _, gomod := range []string{"go go1.21\n", "toolchain 1.21.5\n", "go\n",
+		"go 1.21 1.22\n"} {
+		if _, err := parsemodule(strings.NewReader(gomod)); err == nil {
+			t.Errorf("%q: expected err != nil", gomod)
+		}
+	}
+}
+
+// optversion parses the go version s, without the "go" prefix.  An empty s
+// means the zero Version.
+func optversion(s string) version.Version {
+	if s == "" {
+		return version.Version{}
+	}
+
+	return version.Must(version.Parse("go" + s))
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/perillo/go-compatible/internal/invoke"
+)
+
+// sharded returns a tool function that invokes tool concurrently on n shards
+// of the packages named by the patterns, as reported by go list, and merges
+// the results in shard order, so that the merged diagnostic message is
+// deterministic.
+//
+// When go list fails, tool is invoked on the patterns, so that the error is
+// reported by the tool.  Sharding is disabled in dry run mode.
+func sharded(tool toolfunc, n int) toolfunc {
+	return func(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+		if opts.DryRun {
+			return tool(ctx, rel, plat, patterns, opts)
+		}
+		pkgs, err := golist(ctx, rel, plat, patterns, opts)
+		if err != nil {
+			var cmderr *invoke.Error
+			if !errors.As(err, &cmderr) || ctx.Err() != nil {
+				return nil, err
+			}
+
+			return tool(ctx, rel, plat, patterns, opts)
+		}
+		shards := shard(pkgs, n)
+		if len(shards) <= 1 {
+			return tool(ctx, rel, plat, patterns, opts)
+		}
+
+		msgs := make([][]byte, len(shards))
+		errs := make([]error, len(shards))
+		var wg sync.WaitGroup
+		for i, pkgs := range shards {
+			i, pkgs := i, pkgs
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				msgs[i], errs[i] = tool(ctx, rel, plat, pkgs, opts)
+			}()
+		}
+		wg.Wait()
+
+		return merge(msgs, errs)
+	}
+}
+
+// golist returns the import paths of the packages named by the patterns, for
+// the specified release and platform.
+func golist(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]string, error) {
+	env, err := toolenv(rel, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %w", rel, rel.GoRoot, err)
+	}
+	args := goargs("list", patterns, tagsflags(opts))
+	cmd := exec.CommandContext(ctx, gocommand(rel.GoRoot), args...)
+	cmd.Env = environ(rel, plat, env, opts.CleanEnv)
+	cmd.Dir = opts.Dir
+
+	stdout, err := invoke.OutputContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(stdout)), nil
+}
+
+// shard splits the list of packages in at most n contiguous shards of
+// similar size, preserving the order.
+func shard(pkgs []string, n int) [][]string {
+	if n > len(pkgs) {
+		n = len(pkgs)
+	}
+
+	shards := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		lo := i * len(pkgs) / n
+		hi := (i + 1) * len(pkgs) / n
+		shards = append(shards, pkgs[lo:hi])
+	}
+
+	return shards
+}
+
+// merge merges the diagnostic messages and the errors returned by the tool
+// for each shard.  The messages are joined in shard order.  The returned error
+// is, in order of precedence, the first error other than errFailed and
+// errNoTests, errFailed, nil when a shard reported a diagnostic message
+// without an error, and errNoTests.
+func merge(msgs [][]byte, errs []error) ([]byte, error) {
+	var list [][]byte
+	for _, msg := range msgs {
+		if len(msg) > 0 {
+			list = append(list, msg)
+		}
+	}
+	var msg []byte
+	if list != nil {
+		msg = bytes.Join(list, []byte("\n"))
+	}
+
+	var failed, diagnostics, notests bool
+	for i, err := range errs {
+		switch {
+		case err == errFailed:
+			failed = true
+		case err == errNoTests:
+			notests = true
+		case err != nil:
+			return nil, err
+		case msgs[i] != nil:
+			diagnostics = true
+		}
+	}
+	switch {
+	case failed:
+		return msg, errFailed
+	case diagnostics:
+		return msg, nil
+	case notests:
+		return msg, errNoTests
+	}
+
+	return msg, nil
+}
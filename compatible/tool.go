@@ -0,0 +1,687 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/perillo/go-compatible/internal/invoke"
+	"github.com/perillo/go-compatible/internal/logging"
+	"github.com/perillo/go-compatible/version"
+)
+
+// environ returns the environment to use when invoking the go command for
+// the specified release and platform, with the additional environment
+// variables in extra.  The environment is inherited from the current process
+// unless clean is set, in which case only the variables in cleanenv are
+// inherited.
+func environ(rel Release, plat Platform, extra []string, clean bool) []string {
+	env := os.Environ()
+	if clean {
+		env = minimalenv()
+	}
+
+	return append(env, envvars(rel, plat, extra)...)
+}
+
+// cleanenv contains the environment variables inherited by the go command in
+// a clean environment, required to find the executables, the home directory
+// and the temporary directory.
+var cleanenv = []string{
+	"PATH", "HOME", "TMPDIR",
+	// Windows.
+	"USERPROFILE", "SYSTEMROOT", "TEMP", "TMP", "LOCALAPPDATA", "APPDATA",
+}
+
+// minimalenv returns the variables in cleanenv that are set in the current
+// process.
+func minimalenv() []string {
+	var env []string
+	for _, key := range cleanenv {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	return env
+}
+
+// envvars returns the environment variables set by go-compatible when
+// invoking the go command for the specified release and platform, preceded by
+// the additional environment variables in extra.  Since the last value takes
+// precedence, the GOROOT, GOOS and GOARCH variables set by go-compatible
+// override the ones in extra.
+func envvars(rel Release, plat Platform, extra []string) []string {
+	env := append([]string{}, extra...)
+	env = append(env, "GOROOT="+rel.GoRoot)
+	if plat.GOOS != "" {
+		env = append(env, "GOOS="+plat.GOOS)
+	}
+	if plat.GOARCH != "" {
+		env = append(env, "GOARCH="+plat.GOARCH)
+	}
+
+	return env
+}
+
+// Env returns the environment variables set when invoking the go command for
+// the specified release and platform with opts, in the order they are set:
+// the variables from opts, like GOPROXY and Env, followed by GOROOT, GOOS and
+// GOARCH.  Since the last value takes precedence, a variable may be repeated.
+// The other variables are inherited from the current process, unless
+// opts.CleanEnv is set; in this case the inherited variables are also
+// returned, first.  The build cache directory, if any, is not created.
+func Env(rel Release, plat Platform, opts Options) ([]string, error) {
+	opts.DryRun = true // do not create the build cache directory
+	extra, err := toolenv(rel, opts)
+	if err != nil {
+		return nil, err
+	}
+	env := envvars(rel, plat, extra)
+	if opts.CleanEnv {
+		env = append(minimalenv(), env...)
+	}
+
+	return env, nil
+}
+
+// printcmd prints the command line of cmd to w, prefixed by the environment
+// variables set for the specified release and platform and the additional
+// environment variables in extra.  If cmd.Dir is set, the command line is
+// preceded by a line changing the working directory.  The values are quoted
+// as needed, so that the lines can be pasted in a POSIX shell.
+func printcmd(w io.Writer, rel Release, plat Platform, extra []string, cmd *exec.Cmd) {
+	if cmd.Dir != "" {
+		fmt.Fprintln(w, "cd", invoke.Quote(cmd.Dir))
+	}
+	var line []string
+	for _, kv := range envvars(rel, plat, extra) {
+		// The variable name must not be quoted, otherwise the shell would
+		// parse the assignment as a command.
+		i := strings.Index(kv, "=")
+		line = append(line, kv[:i+1]+invoke.Quote(kv[i+1:]))
+	}
+	line = append(line, invoke.CommandLine(cmd.Path, cmd.Args[1:]))
+	fmt.Fprintln(w, strings.Join(line, " "))
+}
+
+// goclean invokes go clean to clean the files generated by go build in the
+// specified directory, for versions older than go1.8.  An empty dir means the
+// current directory.
+func goclean(dir string) error {
+	// Use the go command installed in the system.
+	cmd := exec.Command("go", "clean")
+	cmd.Dir = dir
+
+	return invoke.Run(cmd)
+}
+
+// GoCmd is the name of the go command in the bin directory of each release.
+var GoCmd = "go"
+
+// gocommand returns the path of the go command from goroot, using the name in
+// GoCmd.  On Windows the ".exe" extension is added when the name has no
+// extension.
+func gocommand(goroot string) string {
+	return filepath.Join(goroot, "bin", exename(GoCmd, runtime.GOOS))
+}
+
+// exename returns the name of the executable file for the command name on the
+// specified operating system.
+func exename(name, goos string) string {
+	if goos == "windows" && filepath.Ext(name) == "" {
+		return name + ".exe"
+	}
+
+	return name
+}
+
+// VersionTimeout is the maximum time allowed to the go version command of
+// each release, so that a broken go command can not block the discovery of
+// the releases.  A value <= 0 means no timeout.
+var VersionTimeout = 10 * time.Second
+
+// goversion returns the version of go from goroot.  The go command is killed
+// when it does not complete within VersionTimeout; in this case the returned
+// error wraps context.DeadlineExceeded.
+func goversion(goroot string) (string, error) {
+	ctx := context.Background()
+	if VersionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, VersionTimeout)
+		defer cancel()
+	}
+
+	gocmd := gocommand(goroot)
+	cmd := exec.CommandContext(ctx, gocmd, "version")
+	cmd.Env = append(os.Environ(), "GOROOT="+goroot)
+
+	stdout, err := invoke.OutputContext(ctx, cmd)
+	if err != nil {
+		// TODO(mperillo): Ignore the case of gocmd not found.
+		return "", fmt.Errorf("goroot %s: %w", goroot, err)
+	}
+
+	return string(stdout), nil
+}
+
+// govet invokes go vet on the packages named by the given patterns, for the
+// specified release and platform.  It returns the diagnostic message and a
+// non nil error, in case of a fatal error like go command not found.
+//
+// Releases older than go1.5 do not include the vet tool; in this case govet
+// returns a message suggesting how to proceed, without invoking the go
+// command.
+func govet(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+	if !rel.Devel && rel.Version.Less(go15) {
+		return nil, errNoVet
+	}
+
+	msg, err := gotool(ctx, rel, plat, "vet", patterns, vetflags(opts), opts)
+	if err != nil {
+		return nil, err
+	}
+	if isunknowncmd(msg) {
+		return nil, errNoVet
+	}
+	if name := unknownanalyzer(msg, opts.Analyzers); name != "" {
+		return nil, &skipError{"vet analyzer " + name + " not available"}
+	}
+
+	return msg, nil
+}
+
+// unknownanalyzer returns the name of the analyzer in analyzers that stderr
+// reports as an unknown flag, or an empty string.  The available analyzers
+// differ by release.
+func unknownanalyzer(stderr []byte, analyzers []string) string {
+	const prefix = "flag provided but not defined: -"
+
+	i := bytes.Index(stderr, []byte(prefix))
+	if i < 0 {
+		return ""
+	}
+	flag := stderr[i+len(prefix):]
+	if j := bytes.IndexAny(flag, " \n"); j >= 0 {
+		flag = flag[:j]
+	}
+	for _, a := range analyzers {
+		if name := analyzername(a); name == string(flag) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// analyzername returns the name of the analyzer in the analyzer setting a,
+// like "printf" in "printf=false".
+func analyzername(a string) string {
+	if i := strings.Index(a, "="); i >= 0 {
+		return a[:i]
+	}
+
+	return a
+}
+
+// go15 is the first release that includes the vet tool in the distribution.
+var go15 = version.Must(version.Parse("go1.5"))
+
+// errNoVet is the error returned by govet when go vet is not available for
+// the release, that is skipped.  The reason suggests the modes that can be
+// used for these releases.
+var errNoVet = &skipError{"go vet not available, use -mode test or -mode auto"}
+
+// isunknowncmd returns true if stderr reports that the go command does not
+// know the invoked subcommand or tool.
+func isunknowncmd(stderr []byte) bool {
+	return bytes.Contains(stderr, []byte("unknown subcommand")) ||
+		bytes.Contains(stderr, []byte("no such tool"))
+}
+
+var go18 = version.Must(version.Parse("go1.8"))
+
+// go110 is the first release where go vet has access to the complete type
+// information of the packages, reporting the same errors as the compiler.
+var go110 = version.Must(version.Parse("go1.10"))
+
+// automode returns the tool used in auto mode for the release: vet starting
+// with go1.10 and build for older releases, where go build is a more
+// reliable check that the packages compile.  Development versions are
+// assumed to be recent.
+func automode(rel Release) string {
+	if rel.Devel || !rel.Version.Less(go110) {
+		return "vet"
+	}
+
+	return "build"
+}
+
+// goauto invokes go vet or go build on the packages named by the given
+// patterns, for the specified release and platform, according to automode.
+func goauto(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+	if automode(rel) == "build" {
+		return gobuild(ctx, rel, plat, patterns, opts)
+	}
+
+	return govet(ctx, rel, plat, patterns, opts)
+}
+
+// gobuild invokes go build on the packages named by the given patterns, for
+// the specified release and platform.  It returns the diagnostic message and a
+// non nil error, in case of a fatal error like go command not found.
+func gobuild(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+	return gotool(ctx, rel, plat, "build", patterns, buildflags(rel, opts), opts)
+}
+
+// gotest invokes go test on the packages named by the given patterns, for the
+// specified release and platform.  It returns the test report and a non nil
+// error, in case of a fatal error like go command not found.
+//
+// For older versions go test report more errors compared to go vet.
+//
+// When opts.Race is set and the race detector is not supported by the release
+// for the platform, gotest returns a *skipError.  When opts.NoTests is set and
+// go test succeeds, gotest returns the lines reporting the packages with no
+// test files and errNoTests, if there are any.
+//
+// The -shuffle flag, requested with opts.Shuffle, is omitted with a warning
+// for releases older than go1.17.
+func gotest(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+	if opts.Race && !racesupported(rel, plat) {
+		return nil, &skipError{"race detector not supported"}
+	}
+	if opts.Shuffle != "" && !shufflesupported(rel) {
+		logging.Warnf("%s: -shuffle not supported, omitting it",
+			Target(rel, plat, opts))
+		opts.Shuffle = ""
+	}
+
+	return gotool(ctx, rel, plat, "test", patterns, testflags(opts), opts)
+}
+
+// notests returns the lines of the go test report that mark a package with no
+// test files, like "?   	example.com/pkg	[no test files]", or nil.
+func notests(report []byte) []byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(report, []byte("\n")) {
+		if bytes.HasSuffix(bytes.TrimSpace(line), []byte("[no test files]")) {
+			lines = append(lines, line)
+		}
+	}
+	if lines == nil {
+		return nil
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// buildmarkers are the strings that, in the output of go vet and go test,
+// mark a package that does not compile, instead of a diagnostic reported by
+// vet or a failed test.  The first markers are printed by go test, the others
+// are common compiler errors, also reported by go vet when type checking
+// fails.  Releases before go1.20 use "declared but not used".
+var buildmarkers = []string{
+	"[build failed]",
+	"[setup failed]",
+	": undefined: ",
+	": syntax error: ",
+	": cannot use ",
+	" imported and not used",
+	" declared and not used",
+	" declared but not used",
+	"\ntoo many errors",
+}
+
+// compilefailed returns true if the output of the go tool reports that a
+// package does not compile.
+func compilefailed(msg []byte) bool {
+	// Add a leading newline, so that markers at the start of a line also
+	// match on the first line.
+	s := "\n" + string(msg)
+	for _, marker := range buildmarkers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failreason returns the reason of a failure with the diagnostic message msg:
+// "does not compile" when msg reports a package that does not compile, or
+// "diagnostics found".
+func failreason(msg []byte) string {
+	if compilefailed(msg) {
+		return "does not compile"
+	}
+
+	return "diagnostics found"
+}
+
+// gocustom invokes the custom go subcommand in opts.Command on the packages
+// named by the given patterns, for the specified release and platform.  It
+// returns the combined stdout and stderr, also when the command succeeds, and
+// errFailed when the command fails.  A non nil error, other than errFailed,
+// is a fatal error like go command not found.
+func gocustom(ctx context.Context, rel Release, plat Platform, patterns []string, opts Options) ([]byte, error) {
+	subcommand := opts.Command[0]
+	extra := append(opts.Command[1:len(opts.Command):len(opts.Command)],
+		tagsflags(opts)...)
+	extra = append(extra, opts.Args...)
+
+	return gotool(ctx, rel, plat, subcommand, patterns, extra, opts)
+}
+
+// gotool invokes the go subcommand with the extra arguments on the packages
+// named by the given patterns, for the specified release and platform.  It
+// returns the diagnostic message and a non nil error, in case of a fatal
+// error like go command not found.  A fatal error reports the release and its
+// goroot.
+//
+// The diagnostic message is the command stderr, except for go test where it
+// is the combined stdout and stderr.  When opts.Command is set, see gocustom,
+// and when opts.NoTests is set, see gotest.  When opts.Workspace is set,
+// releases that do not support workspaces are skipped.
+func gotool(ctx context.Context, rel Release, plat Platform, subcommand string, patterns, extra []string, opts Options) ([]byte, error) {
+	if err := noworkspace(rel, opts); err != nil {
+		return nil, err
+	}
+
+	env, err := toolenv(rel, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s): %w", rel, rel.GoRoot, err)
+	}
+	gocmd := gocommand(rel.GoRoot)
+	args := goargs(subcommand, patterns, extra)
+	cmd := exec.CommandContext(ctx, gocmd, args...)
+	cmd.Env = environ(rel, plat, env, opts.CleanEnv)
+	cmd.Dir = opts.Dir
+	if opts.DryRun {
+		printcmd(stdout(opts), rel, plat, env, cmd)
+
+		return nil, nil
+	}
+
+	// go test writes the go vet diagnostic on stderr and the test report on
+	// stdout.  The output of a custom command is always reported.
+	custom := len(opts.Command) > 0
+	var msg []byte
+	if subcommand == "test" || custom {
+		msg, err = invoke.CombinedOutputContext(ctx, cmd)
+	} else {
+		err = invoke.RunContext(ctx, cmd)
+		if cmderr, ok := err.(*invoke.Error); ok {
+			msg = cmderr.Stderr
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The process was killed.
+			return nil, ctx.Err()
+		}
+
+		// Determine the error type to decide if there was a fatal problem
+		// with the invocation of the go command, like *exec.Error or
+		// *fs.PathError, that requires the termination of the program.
+		var exiterr *exec.ExitError
+		if errors.As(err, &exiterr) {
+			if custom {
+				return msg, errFailed
+			}
+
+			return msg, nil
+		}
+
+		return nil, fmt.Errorf("%s (%s): %w", rel, rel.GoRoot, err)
+	}
+	if custom && len(msg) > 0 {
+		return msg, nil
+	}
+	if subcommand == "test" && opts.NoTests {
+		if lines := notests(msg); lines != nil {
+			return lines, errNoTests
+		}
+	}
+
+	return nil, nil
+}
+
+// stdout returns opts.Stdout, or os.Stdout if it is nil.
+func stdout(opts Options) io.Writer {
+	if opts.Stdout == nil {
+		return os.Stdout
+	}
+
+	return opts.Stdout
+}
+
+// toolenv returns the additional environment variables for the go command
+// invoked for the specified release: the variables in versionenv when
+// opts.VersionEnv is set, GOPROXY and GOSUMDB when opts.Proxy and opts.SumDB
+// are set, opts.Env and, when opts.CacheDir is set, the GOCACHE variable with
+// the build cache of the release.  Since the last value takes precedence,
+// opts.Env overrides the other variables.  The build cache directory is
+// created as needed, except in dry run mode.
+func toolenv(rel Release, opts Options) ([]string, error) {
+	var env []string
+	if opts.VersionEnv {
+		lookup := os.LookupEnv
+		if opts.CleanEnv {
+			lookup = func(string) (string, bool) { return "", false }
+		}
+		env = append(env, releaseenv(rel, lookup)...)
+	}
+	if opts.Proxy != "" {
+		env = append(env, "GOPROXY="+opts.Proxy)
+	}
+	if opts.SumDB != "" {
+		env = append(env, "GOSUMDB="+opts.SumDB)
+	}
+	env = append(env, opts.Env...)
+	if opts.CacheDir == "" {
+		return env, nil
+	}
+
+	dir := gocache(rel, opts.CacheDir)
+	if !opts.DryRun {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(env, "GOCACHE="+dir), nil
+}
+
+// versionenv contains the environment variables set for the releases in a
+// version range, so that all the releases have the same module behavior.
+var versionenv = []struct {
+	constraint version.Constraint
+	key        string
+	value      string
+}{
+	// Enable module mode also inside GOPATH, as done by default since
+	// go1.13.
+	{mustconstraint(">=1.11 <1.13"), "GO111MODULE", "on"},
+	// Do not update go.mod, as done by default since go1.16.
+	{mustconstraint(">=1.11 <1.16"), "GOFLAGS", "-mod=readonly"},
+}
+
+// releaseenv returns the environment variables in versionenv for the
+// specified release.  Development versions are assumed to be recent.
+//
+// lookup returns the value of an inherited variable.  A variable already set
+// is not overridden, except for GOFLAGS, where the flag is appended to the
+// inherited flags, unless they already include it, like -mod=vendor.
+func releaseenv(rel Release, lookup func(key string) (string, bool)) []string {
+	if rel.Devel {
+		return nil
+	}
+
+	var env []string
+	for _, e := range versionenv {
+		if !e.constraint.Matches(rel.Version) {
+			continue
+		}
+		value, ok := lookup(e.key)
+		switch {
+		case !ok || value == "":
+			value = e.value
+		case e.key == "GOFLAGS" && !hasflag(value, e.value):
+			value += " " + e.value
+		default:
+			continue
+		}
+		env = append(env, e.key+"="+value)
+	}
+
+	return env
+}
+
+// hasflag returns true if the space-separated flags, as in GOFLAGS, include
+// the flag in f, with any value.  As an example, -mod=vendor includes
+// -mod=readonly.
+func hasflag(flags, f string) bool {
+	name := f
+	if i := strings.Index(f, "="); i >= 0 {
+		name = f[:i]
+	}
+	for _, s := range strings.Fields(flags) {
+		if s == name || strings.HasPrefix(s, name+"=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mustconstraint is like version.ParseConstraint but panics if the
+// constraint can not be parsed.
+func mustconstraint(s string) version.Constraint {
+	c, err := version.ParseConstraint(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// gocache returns the path of the build cache for the specified release, in
+// the root directory.  The path is keyed by the name of the release goroot,
+// that is unique in the sdk directory.
+func gocache(rel Release, root string) string {
+	return filepath.Join(root, filepath.Base(rel.GoRoot))
+}
+
+// goargs returns the arguments for the go subcommand, with the extra
+// arguments inserted between the subcommand and the package patterns.
+func goargs(subcommand string, patterns, extra []string) []string {
+	args := []string{subcommand}
+	args = append(args, extra...)
+
+	return append(args, patterns...)
+}
+
+// vetflags returns the extra arguments for go vet.  The analyzers in
+// opts.Analyzers are passed as vet flags, like -printf or -shadow=false.
+func vetflags(opts Options) []string {
+	flags := tagsflags(opts)
+	for _, a := range opts.Analyzers {
+		flags = append(flags, "-"+a)
+	}
+
+	return append(flags, opts.Args...)
+}
+
+// buildflags returns the extra arguments for go build, for the specified
+// release.  Development versions are assumed to be recent.
+func buildflags(rel Release, opts Options) []string {
+	var flags []string
+	if rel.Devel || !rel.Version.Less(go18) {
+		// Invoke `go build -o /dev/null [packages]`.
+		// Note that this is not documented.
+		//
+		// For older releases invoke `go build [packages]`.  It is not the
+		// default choice because, in case patterns match a single main
+		// package, go build will write the generated binary in the current
+		// directory.
+		flags = append(flags, "-o", os.DevNull)
+	}
+	flags = append(flags, tagsflags(opts)...)
+
+	return append(flags, opts.Args...)
+}
+
+// testflags returns the extra arguments for go test.
+func testflags(opts Options) []string {
+	var flags []string
+	if opts.Race {
+		flags = append(flags, "-race")
+	}
+	if opts.Shuffle != "" {
+		flags = append(flags, "-shuffle="+opts.Shuffle)
+	}
+	if opts.PkgTimeout > 0 {
+		flags = append(flags, "-timeout="+opts.PkgTimeout.String())
+	}
+	flags = append(flags, tagsflags(opts)...)
+
+	return append(flags, opts.Args...)
+}
+
+// go117 is the first release where go test supports the -shuffle flag.
+var go117 = version.Must(version.Parse("go1.17"))
+
+// shufflesupported returns true if go test from rel supports the -shuffle
+// flag.  Development versions are assumed to be recent.
+func shufflesupported(rel Release) bool {
+	return rel.Devel || !rel.Version.Less(go117)
+}
+
+// tagsflags returns the -tags argument for the go tool, if build tags are
+// specified.
+func tagsflags(opts Options) []string {
+	if opts.Tags == "" {
+		return nil
+	}
+
+	return []string{"-tags", opts.Tags}
+}
+
+// racefirst maps the platforms supporting the race detector to the first
+// release supporting it.
+var racefirst = map[Platform]version.Version{
+	{"linux", "amd64"}:   version.Must(version.Parse("go1.1")),
+	{"darwin", "amd64"}:  version.Must(version.Parse("go1.1")),
+	{"freebsd", "amd64"}: version.Must(version.Parse("go1.1")),
+	{"windows", "amd64"}: version.Must(version.Parse("go1.1")),
+	{"netbsd", "amd64"}:  version.Must(version.Parse("go1.8")),
+	{"linux", "ppc64le"}: version.Must(version.Parse("go1.10")),
+	{"linux", "arm64"}:   version.Must(version.Parse("go1.12")),
+	{"darwin", "arm64"}:  version.Must(version.Parse("go1.16")),
+	{"linux", "s390x"}:   version.Must(version.Parse("go1.19")),
+}
+
+// racesupported returns true if the race detector is supported by the
+// specified release for the specified platform.  Development versions are
+// assumed to be recent.
+func racesupported(rel Release, plat Platform) bool {
+	first, ok := racefirst[plat.resolve()]
+	if !ok {
+		return false
+	}
+
+	return rel.Devel || !rel.Version.Less(first)
+}
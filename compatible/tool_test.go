@@ -0,0 +1,980 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perillo/go-compatible/internal/invoke"
+	"github.com/perillo/go-compatible/version"
+)
+
+// TestGovetUnavailable tests that govet skips the releases that do not
+// include the vet tool.
+func TestGovetUnavailable(t *testing.T) {
+	t.Run("go1.4", func(t *testing.T) {
+		// The go command must not be invoked.
+		rel := Release{
+			GoRoot:  filepath.Join(t.TempDir(), "go1.4"),
+			Version: version.Must(version.Parse("go1.4")),
+		}
+		_, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, Options{})
+		validateNoVet(t, err)
+	})
+
+	t.Run("devel", func(t *testing.T) {
+		// A development version only reporting the commit hash has a zero
+		// version.
+		rel := fakeRelease(t, "go1.16", "echo 'vet: failure' >&2; exit 1")
+		rel.Version = version.Version{}
+		rel.Devel = true
+		msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, Options{})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		if s := string(msg); s != "vet: failure" {
+			t.Errorf("want msg = %q, got %q", "vet: failure", s)
+		}
+	})
+
+	t.Run("no such tool", func(t *testing.T) {
+		const script = `echo 'go tool: no such tool "vet"' >&2; exit 2`
+
+		rel := fakeRelease(t, "go1.5", script)
+		_, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, Options{})
+		validateNoVet(t, err)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		rel := Release{
+			GoRoot:  filepath.Join(t.TempDir(), "go1.4"),
+			Version: version.Must(version.Parse("go1.4")),
+		}
+		results, err := Run(context.Background(), []Release{rel}, []string{"./..."},
+			Options{Mode: "vet"})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		validateResult(t, results[0], Skip,
+			"go vet not available, use -mode test or -mode auto", "")
+	})
+}
+
+// TestPlatformEnv tests that the GOOS and GOARCH environment variables are
+// set in the go command invoked for each platform.
+func TestPlatformEnv(t *testing.T) {
+	const script = `echo "$GOROOT $GOOS/$GOARCH" >&2; exit 1`
+
+	rel := fakeRelease(t, "go1.16", script)
+	for _, plat := range Platforms("linux,windows", "amd64,arm64") {
+		msg, err := govet(context.Background(), rel, plat, []string{"./..."}, Options{})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+
+		want := rel.GoRoot + " " + plat.GOOS + "/" + plat.GOARCH
+		if string(msg) != want {
+			t.Errorf("want msg = %q, got %q", want, msg)
+		}
+	}
+}
+
+// TestEnv tests that the additional environment variables are set when
+// invoking the go command, and that they do not override GOROOT.
+func TestEnv(t *testing.T) {
+	const script = `echo "$GOROOT $GOFLAGS $CGO_ENABLED" >&2; exit 1`
+
+	rel := fakeRelease(t, "go1.16", script)
+	opts := Options{Env: []string{"GOFLAGS=-mod=mod", "CGO_ENABLED=0", "GOROOT=/"}}
+	msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	want := rel.GoRoot + " -mod=mod 0"
+	if string(msg) != want {
+		t.Errorf("want msg = %q, got %q", want, msg)
+	}
+}
+
+// TestCacheDir tests that a distinct GOCACHE is set for each release when
+// opts.CacheDir is set, and that the build cache directory is created.
+func TestCacheDir(t *testing.T) {
+	const script = `echo "$GOCACHE" >&2; exit 1`
+
+	root := t.TempDir()
+	opts := Options{Env: []string{"GOCACHE=/shared"}, CacheDir: root}
+	seen := make(map[string]bool)
+	for _, goversion := range []string{"go1.16", "go1.17"} {
+		rel := fakeRelease(t, goversion, script)
+		msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, opts)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", goversion, err)
+		}
+
+		want := filepath.Join(root, goversion)
+		if string(msg) != want {
+			t.Errorf("%s: want GOCACHE = %q, got %q", goversion, want, msg)
+		}
+		if fi, err := os.Stat(want); err != nil || !fi.IsDir() {
+			t.Errorf("%s: expected directory %s, got %v", goversion, want, err)
+		}
+		if seen[string(msg)] {
+			t.Errorf("%s: GOCACHE %q already used", goversion, msg)
+		}
+		seen[string(msg)] = true
+	}
+}
+
+// TestVersionEnv tests that the environment variables for old releases are
+// chosen by version, that they do not override the inherited variables and
+// that they can be overridden by opts.Env.
+func TestVersionEnv(t *testing.T) {
+	var tests = []struct {
+		goversion string
+		want      []string
+	}{
+		{"go1.10", nil},
+		{"go1.11", []string{"GO111MODULE=on", "GOFLAGS=-mod=readonly"}},
+		{"go1.12.17", []string{"GO111MODULE=on", "GOFLAGS=-mod=readonly"}},
+		{"go1.13", []string{"GOFLAGS=-mod=readonly"}},
+		{"go1.15.15", []string{"GOFLAGS=-mod=readonly"}},
+		{"go1.16beta1", []string{"GOFLAGS=-mod=readonly"}},
+		{"go1.16", nil},
+		{"go1.21.0", nil},
+	}
+	for _, test := range tests {
+		rel := Release{Version: version.Must(version.Parse(test.goversion))}
+		if env := releaseenv(rel, lookupenv(nil)); !reflect.DeepEqual(env, test.want) {
+			t.Errorf("%s: want env = %q, got %q", test.goversion, test.want, env)
+		}
+	}
+	if env := releaseenv(Release{Devel: true}, lookupenv(nil)); env != nil {
+		t.Errorf("devel: want env = nil, got %q", env)
+	}
+
+	var inherited = []struct {
+		env  map[string]string
+		want []string
+	}{
+		{
+			map[string]string{"GO111MODULE": "", "GOFLAGS": ""},
+			[]string{"GO111MODULE=on", "GOFLAGS=-mod=readonly"},
+		},
+		{
+			map[string]string{"GO111MODULE": "auto", "GOFLAGS": "-tags=x"},
+			[]string{"GOFLAGS=-tags=x -mod=readonly"},
+		},
+		{
+			map[string]string{"GOFLAGS": "-tags=x -mod=vendor"},
+			[]string{"GO111MODULE=on"},
+		},
+		{
+			map[string]string{"GOFLAGS": "-modcacherw"},
+			[]string{"GO111MODULE=on", "GOFLAGS=-modcacherw -mod=readonly"},
+		},
+	}
+	rel := Release{Version: version.Must(version.Parse("go1.12"))}
+	for _, test := range inherited {
+		if env := releaseenv(rel, lookupenv(test.env)); !reflect.DeepEqual(env, test.want) {
+			t.Errorf("%q: want env = %q, got %q", test.env, test.want, env)
+		}
+	}
+
+	const script = `echo "$GO111MODULE $GOFLAGS" >&2; exit 1`
+
+	unsetenv(t, "GO111MODULE", "GOFLAGS")
+	rel = fakeRelease(t, "go1.12", script)
+	var runs = []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, ""},
+		{Options{VersionEnv: true}, "on -mod=readonly"},
+		{Options{VersionEnv: true, Env: []string{"GOFLAGS=-mod=vendor"}}, "on -mod=vendor"},
+	}
+	for _, run := range runs {
+		msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, run.opts)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		if string(msg) != run.want {
+			t.Errorf("%+v: want msg = %q, got %q", run.opts, run.want, msg)
+		}
+	}
+
+	os.Setenv("GO111MODULE", "off")
+	os.Setenv("GOFLAGS", "-tags=x")
+	msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."},
+		Options{VersionEnv: true})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if want := "off -tags=x -mod=readonly"; string(msg) != want {
+		t.Errorf("inherited: want msg = %q, got %q", want, msg)
+	}
+}
+
+// lookupenv returns a function like os.LookupEnv, looking up the variables
+// in env.
+func lookupenv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+
+		return value, ok
+	}
+}
+
+// TestProxyEnv tests that the GOPROXY and GOSUMDB environment variables are
+// passed to the go command when opts.Proxy and opts.SumDB are set.
+func TestProxyEnv(t *testing.T) {
+	const script = `echo "$GOPROXY|$GOSUMDB|$GOFLAGS" >&2; exit 1`
+
+	unsetenv(t, "GOPROXY", "GOSUMDB", "GOFLAGS")
+	rel := fakeRelease(t, "go1.13", script)
+	var runs = []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "||"},
+		{Options{Proxy: "off"}, "off||"},
+		{Options{Proxy: "http://localhost:3000", SumDB: "off"}, "http://localhost:3000|off|"},
+		{Options{Proxy: "off", VersionEnv: true}, "off||-mod=readonly"},
+		{Options{Proxy: "off", Env: []string{"GOPROXY=direct"}}, "direct||"},
+	}
+	for _, run := range runs {
+		msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, run.opts)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		if string(msg) != run.want {
+			t.Errorf("%+v: want msg = %q, got %q", run.opts, run.want, msg)
+		}
+	}
+}
+
+// TestCleanEnv tests that the go command does not inherit the environment,
+// except for the variables in cleanenv, when opts.CleanEnv is set.
+func TestCleanEnv(t *testing.T) {
+	const script = `echo "$GO_COMPATIBLE_SENTINEL|$GOFLAGS|$PATH|$EXTRA" >&2; exit 1`
+
+	for _, key := range []string{"GO_COMPATIBLE_SENTINEL", "GOFLAGS"} {
+		key := key
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, "parent")
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+	path := os.Getenv("PATH")
+	rel := fakeRelease(t, "go1.16", script)
+	var runs = []struct {
+		opts Options
+		want string
+	}{
+		{Options{}, "parent|parent|" + path + "|"},
+		{Options{CleanEnv: true}, "||" + path + "|"},
+		{Options{CleanEnv: true, Env: []string{"EXTRA=1"}}, "||" + path + "|1"},
+	}
+	for _, run := range runs {
+		msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, run.opts)
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		if string(msg) != run.want {
+			t.Errorf("%+v: want msg = %q, got %q", run.opts, run.want, msg)
+		}
+	}
+}
+
+// unsetenv unsets the environment variables for the duration of the test.
+func unsetenv(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		key := key
+		old, ok := os.LookupEnv(key)
+		os.Unsetenv(key)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+// TestAnalyzers tests that the vet analyzers are passed to go vet, and that a
+// release not supporting an analyzer is skipped.
+func TestAnalyzers(t *testing.T) {
+	opts := Options{
+		Analyzers: []string{"printf", "shadow=false"},
+		Tags:      "integration",
+		Args:      []string{"-v"},
+	}
+	args := goargs("vet", []string{"./..."}, vetflags(opts))
+	want := []string{"vet", "-tags", "integration", "-printf", "-shadow=false",
+		"-v", "./..."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args = %q, got %q", want, args)
+	}
+
+	// The go command does not know the shadow analyzer.
+	const script = `for arg; do
+case "$arg" in -shadow*)
+	echo "flag provided but not defined: -shadow" >&2
+	echo "usage: go vet [build flags] [-vettool prog] [vet flags] [packages]" >&2
+	exit 2 ;;
+esac
+done
+echo "$*" >&2; exit 1`
+
+	ctx := context.Background()
+	rel := fakeRelease(t, "go1.12", script)
+	_, err := govet(ctx, rel, Platform{}, []string{"./..."}, opts)
+	var skiperr *skipError
+	if !errors.As(err, &skiperr) {
+		t.Fatalf("expected err as %T, got %v", skiperr, err)
+	}
+	if want := "vet analyzer shadow not available"; skiperr.reason != want {
+		t.Errorf("want reason = %q, got %q", want, skiperr.reason)
+	}
+
+	opts.Analyzers = []string{"printf"}
+	msg, err := govet(ctx, rel, Platform{}, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if want := "vet -tags integration -printf -v ./..."; string(msg) != want {
+		t.Errorf("want msg = %q, got %q", want, msg)
+	}
+}
+
+// TestTestflagsRace tests that the -race argument is included in the go test
+// arguments when the race option is set.
+func TestTestflagsRace(t *testing.T) {
+	patterns := []string{"./..."}
+
+	args := goargs("test", patterns, testflags(Options{Race: true}))
+	want := []string{"test", "-race", "./..."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args = %q, got %q", want, args)
+	}
+
+	args = goargs("test", patterns, testflags(Options{}))
+	want = []string{"test", "./..."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args = %q, got %q", want, args)
+	}
+}
+
+// TestPkgTimeout tests that the -timeout flag is passed to go test with the
+// duration in Options.PkgTimeout.
+func TestPkgTimeout(t *testing.T) {
+	patterns := []string{"./..."}
+
+	opts := Options{PkgTimeout: 90 * time.Second, Race: true}
+	args := goargs("test", patterns, testflags(opts))
+	want := []string{"test", "-race", "-timeout=1m30s", "./..."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args = %q, got %q", want, args)
+	}
+
+	args = goargs("test", patterns, testflags(Options{}))
+	want = []string{"test", "./..."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("want args = %q, got %q", want, args)
+	}
+}
+
+// TestAutoMode tests that in auto mode go vet is invoked for the releases
+// starting with go1.10 and go build for the older releases.
+func TestAutoMode(t *testing.T) {
+	var tests = []struct {
+		goversion string
+		want      string
+	}{
+		{"go1.4", "build"},
+		{"go1.9.7", "build"},
+		{"go1.10beta1", "build"},
+		{"go1.10", "vet"},
+		{"go1.21.0", "vet"},
+	}
+	for _, test := range tests {
+		rel := Release{Version: version.Must(version.Parse(test.goversion))}
+		if s := automode(rel); s != test.want {
+			t.Errorf("%s: want tool = %q, got %q", test.goversion, test.want, s)
+		}
+	}
+	if s := automode(Release{Devel: true}); s != "vet" {
+		t.Errorf("devel: want tool = %q, got %q", "vet", s)
+	}
+
+	// The fake go command prints the subcommand.
+	const script = `echo "$1" >&2; exit 1`
+	releases := []Release{
+		fakeRelease(t, "go1.9", script),
+		fakeRelease(t, "go1.10", script),
+	}
+	opts := Options{Mode: "auto"}
+	results, err := Run(context.Background(), releases, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 results, got %d", len(results))
+	}
+	for i, want := range []string{"build", "vet"} {
+		res := results[i]
+		if res.Tool != want {
+			t.Errorf("%s: want res.Tool = %q, got %q", res.Release, want, res.Tool)
+		}
+		if s := string(res.Msg); s != want {
+			t.Errorf("%s: want res.Msg = %q, got %q", res.Release, want, s)
+		}
+	}
+}
+
+// TestShuffle tests that the -shuffle flag is passed to go test only for the
+// releases supporting it.
+func TestShuffle(t *testing.T) {
+	// The fake go command prints its arguments.
+	const script = `echo "$@"; exit 1`
+
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	releases := []Release{
+		fakeRelease(t, "go1.16", script),
+		fakeRelease(t, "go1.17", script),
+	}
+	buf := new(bytes.Buffer)
+	defer func(w io.Writer) {
+		log.SetOutput(w)
+	}(log.Writer())
+	log.SetOutput(buf)
+
+	opts := Options{Mode: "test", Shuffle: "42"}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	validateResult(t, results[0], Fail, "diagnostics found", "test ./...")
+	validateResult(t, results[1], Fail, "diagnostics found", "test -shuffle=42 ./...")
+
+	want := "warning: go1.16: -shuffle not supported, omitting it\n"
+	if s := buf.String(); !strings.HasSuffix(s, want) {
+		t.Errorf("want log = %q, got %q", want, s)
+	}
+}
+
+// TestRaceSupported tests the detection of the releases and platforms
+// supporting the race detector.
+func TestRaceSupported(t *testing.T) {
+	var tests = []struct {
+		version string
+		plat    Platform
+		want    bool
+	}{
+		{"go1.16", Platform{"linux", "amd64"}, true},
+		{"go1.0", Platform{"linux", "amd64"}, false},
+		{"go1.16", Platform{"linux", "386"}, false},
+		{"go1.11", Platform{"linux", "arm64"}, false},
+		{"go1.12", Platform{"linux", "arm64"}, true},
+	}
+	for _, test := range tests {
+		rel := Release{Version: version.Must(version.Parse(test.version))}
+		if got := racesupported(rel, test.plat); got != test.want {
+			t.Errorf("%s %s: want %t, got %t", test.version, test.plat,
+				test.want, got)
+		}
+	}
+
+	// A development version only reporting the commit hash has a zero
+	// version.
+	devel := Release{Devel: true}
+	if !racesupported(devel, Platform{"linux", "amd64"}) {
+		t.Error("devel linux/amd64: want true, got false")
+	}
+	if racesupported(devel, Platform{"linux", "386"}) {
+		t.Error("devel linux/386: want false, got true")
+	}
+}
+
+// TestToolargs tests that the additional arguments are inserted between the
+// go subcommand and the package patterns.
+func TestToolargs(t *testing.T) {
+	patterns := []string{"./..."}
+	opts := Options{
+		Race: true,
+		Args: []string{"-tags", "integration"},
+	}
+	go17 := Release{Version: version.Must(version.Parse("go1.7"))}
+	go116 := Release{Version: version.Must(version.Parse("go1.16"))}
+	// A development version only reporting the commit hash has a zero
+	// version.
+	devel := Release{Devel: true}
+
+	var tests = []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"vet", goargs("vet", patterns, vetflags(opts)),
+			[]string{"vet", "-tags", "integration", "./..."}},
+		{"build go1.7", goargs("build", patterns, buildflags(go17, opts)),
+			[]string{"build", "-tags", "integration", "./..."}},
+		{"build go1.16", goargs("build", patterns, buildflags(go116, opts)),
+			[]string{"build", "-o", os.DevNull, "-tags", "integration", "./..."}},
+		{"build devel", goargs("build", patterns, buildflags(devel, opts)),
+			[]string{"build", "-o", os.DevNull, "-tags", "integration", "./..."}},
+		{"test", goargs("test", patterns, testflags(opts)),
+			[]string{"test", "-race", "-tags", "integration", "./..."}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.args, test.want) {
+			t.Errorf("%s: want args = %q, got %q", test.name, test.want,
+				test.args)
+		}
+	}
+}
+
+// TestTags tests that the build tags are reported in the output and passed to
+// the go tool.
+func TestTags(t *testing.T) {
+	rel := Release{Version: version.Must(version.Parse("go1.21.0"))}
+	plat := Platform{"linux", "amd64"}
+	patterns := []string{"./..."}
+	opts := Options{
+		Platforms: []Platform{plat},
+		Tags:      "integration",
+	}
+
+	const header = "go1.21.0 linux/amd64 tags=integration"
+	if s := Target(rel, plat, opts); s != header {
+		t.Errorf("want target = %q, got %q", header, s)
+	}
+
+	var tests = []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"vet", goargs("vet", patterns, vetflags(opts)),
+			[]string{"vet", "-tags", "integration", "./..."}},
+		{"build", goargs("build", patterns, buildflags(rel, opts)),
+			[]string{"build", "-o", os.DevNull, "-tags", "integration", "./..."}},
+		{"test", goargs("test", patterns, testflags(opts)),
+			[]string{"test", "-tags", "integration", "./..."}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.args, test.want) {
+			t.Errorf("%s: want args = %q, got %q", test.name, test.want,
+				test.args)
+		}
+	}
+}
+
+// TestDryRun tests that, in dry run mode, the go command is not invoked and
+// the command printed to opts.Stdout matches the constructed command.
+func TestDryRun(t *testing.T) {
+	rel := fakeRelease(t, "go1.16", "exit 3")
+	plat := Platform{"linux", "arm64"}
+	patterns := []string{"./..."}
+	out := new(bytes.Buffer)
+	opts := Options{DryRun: true, Stdout: out}
+
+	// The go command fails when invoked.
+	msg, err := govet(context.Background(), rel, plat, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if msg != nil {
+		t.Fatalf("expected msg == nil, got %q", msg)
+	}
+
+	gocmd := filepath.Join(rel.GoRoot, "bin", "go")
+	cmd := exec.Command(gocmd, goargs("vet", patterns, vetflags(opts))...)
+	buf := new(bytes.Buffer)
+	printcmd(buf, rel, plat, nil, cmd)
+
+	want := "GOROOT=" + rel.GoRoot + " GOOS=linux GOARCH=arm64 " + gocmd +
+		" vet ./...\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want command = %q, got %q", want, s)
+	}
+	if s := out.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+
+	// The values with spaces are quoted, but not the variable names.
+	cmd = exec.Command(gocmd, "test", "-run", "Test A", "./...")
+	cmd.Dir = "/my dir"
+	buf.Reset()
+	printcmd(buf, rel, plat, []string{"GOFLAGS=-tags=a -mod=mod"}, cmd)
+	want = "cd '/my dir'\n" +
+		"GOFLAGS='-tags=a -mod=mod' GOROOT=" + rel.GoRoot +
+		" GOOS=linux GOARCH=arm64 " + gocmd + " test -run 'Test A' ./...\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want command = %q, got %q", want, s)
+	}
+}
+
+// TestGotool tests the gotool function with different subcommands, checking
+// the constructed command and the returned diagnostic message.
+func TestGotool(t *testing.T) {
+	// The go command reports its arguments on stdout and stderr, and fails.
+	const script = `echo "stdout: $*"; echo "stderr: $*" >&2; exit 1`
+
+	ctx := context.Background()
+	rel := fakeRelease(t, "go1.16", script)
+	patterns := []string{"./..."}
+	extra := []string{"-tags", "integration"}
+
+	var tests = []struct {
+		subcommand string
+		want       string
+	}{
+		{"vet", "stderr: vet -tags integration ./..."},
+		{"build", "stderr: build -tags integration ./..."},
+		{"test", "stdout: test -tags integration ./...\n" +
+			"stderr: test -tags integration ./..."},
+	}
+	for _, test := range tests {
+		msg, err := gotool(ctx, rel, Platform{}, test.subcommand, patterns,
+			extra, Options{})
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.subcommand, err)
+		}
+		if string(msg) != test.want {
+			t.Errorf("%s: want msg = %q, got %q", test.subcommand, test.want,
+				msg)
+		}
+	}
+
+	// A missing go command is a fatal error.
+	rel.GoRoot = filepath.Join(t.TempDir(), "missing")
+	for _, test := range tests {
+		_, err := gotool(ctx, rel, Platform{}, test.subcommand, patterns,
+			extra, Options{})
+		if err == nil {
+			t.Errorf("%s: expected err != nil", test.subcommand)
+		}
+	}
+
+	// A successful command has no diagnostic message.
+	rel = fakeRelease(t, "go1.16", "echo ok; exit 0")
+	for _, test := range tests {
+		msg, err := gotool(ctx, rel, Platform{}, test.subcommand, patterns,
+			extra, Options{})
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.subcommand, err)
+		}
+		if msg != nil {
+			t.Errorf("%s: expected msg == nil, got %q", test.subcommand, msg)
+		}
+	}
+}
+
+// TestCustomCommand tests that the arguments of a custom subcommand are
+// constructed, and that its output is captured for each release.
+func TestCustomCommand(t *testing.T) {
+	// The go command reports its version and arguments, and fails for
+	// go1.17.
+	const script = `echo "$(basename "$GOROOT"): $*"
+echo "stderr" >&2
+case "$GOROOT" in *go1.17) exit 1 ;; esac`
+
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	opts := Options{
+		Mode:    "vet",
+		Command: []string{"list", "-deps"},
+		Tags:    "integration",
+		Args:    []string{"-e"},
+	}
+	releases := []Release{
+		fakeRelease(t, "go1.16", script),
+		fakeRelease(t, "go1.17", script),
+	}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 results, got %d", len(results))
+	}
+
+	validateResult(t, results[0], Pass, "",
+		"go1.16: list -deps -tags integration -e ./...\nstderr")
+	validateResult(t, results[1], Fail, "command failed",
+		"go1.17: list -deps -tags integration -e ./...\nstderr")
+	for _, res := range results {
+		if res.Tool != "list" {
+			t.Errorf("%s: want res.Tool = %q, got %q", res.Release, "list",
+				res.Tool)
+		}
+	}
+}
+
+// TestNoTests tests that the packages without test files are reported when
+// go test succeeds, without failing the release.
+func TestNoTests(t *testing.T) {
+	// The go test report of a module with a package without test files, and
+	// the test failure for go1.17.
+	const script = `case "$GOROOT" in *go1.17)
+	echo "--- FAIL: TestPkg"; echo "FAIL	example.com/pkg	0.01s"; exit 1 ;;
+esac
+echo "?   	example.com/notest	[no test files]"
+echo "ok  	example.com/pkg	0.01s"`
+
+	ctx := context.Background()
+	patterns := []string{"./..."}
+	releases := []Release{
+		fakeRelease(t, "go1.16", script),
+		fakeRelease(t, "go1.17", script),
+	}
+	opts := Options{Mode: "test"}
+	results, err := Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	validateResult(t, results[0], Pass, "", "")
+
+	opts.NoTests = true
+	results, err = Run(ctx, releases, patterns, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("want 2 results, got %d", len(results))
+	}
+	validateResult(t, results[0], Pass, "no test files",
+		"?   	example.com/notest	[no test files]")
+	validateResult(t, results[1], Fail, "diagnostics found",
+		"--- FAIL: TestPkg\nFAIL	example.com/pkg	0.01s")
+}
+
+// TestFailReason tests that the output of a package that does not compile is
+// classified separately from the vet diagnostics and the test failures.
+func TestFailReason(t *testing.T) {
+	var tests = []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{"vet", "# example.com/pkg\n./pkg.go:10:2: fmt.Printf format %d has arg s of wrong type string",
+			"diagnostics found"},
+		{"test", "--- FAIL: TestPkg (0.00s)\n    pkg_test.go:8: want 1, got 2\nFAIL\nFAIL\texample.com/pkg\t0.01s",
+			"diagnostics found"},
+		{"test build", "# example.com/pkg\n./pkg.go:5:9: undefined: any\nFAIL\texample.com/pkg [build failed]",
+			"does not compile"},
+		{"test setup", "FAIL\texample.com/pkg [setup failed]", "does not compile"},
+		{"vet typecheck", "vet: ./pkg.go:5:9: undefined: slices", "does not compile"},
+		{"syntax", "# example.com/pkg\n./pkg.go:3:6: syntax error: unexpected [, expecting (",
+			"does not compile"},
+		{"unused", "./pkg.go:4:2: \"os\" imported and not used", "does not compile"},
+		{"old unused", "./pkg.go:7:2: x declared but not used", "does not compile"},
+		{"too many", "./pkg.go:1:1: cannot use x (type int) as type string\ntoo many errors",
+			"does not compile"},
+	}
+	for _, test := range tests {
+		if s := failreason([]byte(test.msg)); s != test.want {
+			t.Errorf("%s: want reason = %q, got %q", test.name, test.want, s)
+		}
+	}
+
+	// The reason is reported in the result.
+	const script = `echo "./pkg.go:5:9: undefined: any" >&2; echo "FAIL	example.com/pkg [build failed]"; exit 2`
+	rel := fakeRelease(t, "go1.17", script)
+	results, err := Run(context.Background(), []Release{rel}, []string{"./..."},
+		Options{Mode: "test"})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if s := results[0].Reason; s != "does not compile" {
+		t.Errorf("want reason = %q, got %q", "does not compile", s)
+	}
+}
+
+// TestPkgParallel tests that the packages are split in shards invoked
+// concurrently, and that the diagnostic messages are merged in shard order.
+func TestPkgParallel(t *testing.T) {
+	// The go command lists 5 packages, and reports a diagnostic for each
+	// package except example.com/c.  The first shard is the slowest.
+	const script = `case "$1" in
+list) echo example.com/a; echo example.com/b; echo example.com/c
+	echo example.com/d; echo example.com/e; exit 0 ;;
+esac
+shift
+case "$*" in *example.com/a*) sleep 0.2 ;; esac
+fail=0
+for pkg; do
+	case "$pkg" in
+	example.com/c) ;;
+	*) echo "$pkg: diagnostic" >&2; fail=1 ;;
+	esac
+done
+exit $fail`
+
+	ctx := context.Background()
+	rel := fakeRelease(t, "go1.16", script)
+	patterns := []string{"./..."}
+	var tests = []struct {
+		n    int
+		want string
+	}{
+		{0, "./...: diagnostic"},
+		{2, "example.com/a: diagnostic\nexample.com/b: diagnostic\n" +
+			"example.com/d: diagnostic\nexample.com/e: diagnostic"},
+		{5, "example.com/a: diagnostic\nexample.com/b: diagnostic\n" +
+			"example.com/d: diagnostic\nexample.com/e: diagnostic"},
+		{10, "example.com/a: diagnostic\nexample.com/b: diagnostic\n" +
+			"example.com/d: diagnostic\nexample.com/e: diagnostic"},
+	}
+	for _, test := range tests {
+		opts := Options{Mode: "vet", PkgParallel: test.n}
+		results, err := Run(ctx, []Release{rel}, patterns, opts)
+		if err != nil {
+			t.Fatalf("%d: expected err == nil, got %q", test.n, err)
+		}
+		validateResult(t, results[0], Fail, "diagnostics found", test.want)
+	}
+
+	shards := shard([]string{"a", "b", "c", "d", "e"}, 2)
+	if want := [][]string{{"a", "b"}, {"c", "d", "e"}}; !reflect.DeepEqual(shards, want) {
+		t.Errorf("want shards = %q, got %q", want, shards)
+	}
+	msg, err := merge([][]byte{nil, []byte("x"), nil},
+		[]error{errNoTests, nil, nil})
+	if err != nil || string(msg) != "x" {
+		t.Errorf("want merge = %q, nil, got %q, %v", "x", msg, err)
+	}
+}
+
+// TestGocommand tests that the path of the go command uses the name specified
+// by GoCmd, with the executable extension of the host OS.
+func TestGocommand(t *testing.T) {
+	defer func(name string) { GoCmd = name }(GoCmd)
+
+	ext := ""
+	if runtime.GOOS == "windows" {
+		ext = ".exe"
+	}
+	goroot := filepath.Join("sdk", "go1.16")
+	var tests = []struct {
+		name string
+		want string
+	}{
+		{"go", filepath.Join(goroot, "bin", "go"+ext)},
+		{"go.exe", filepath.Join(goroot, "bin", "go.exe")},
+		{"go-wrapper", filepath.Join(goroot, "bin", "go-wrapper"+ext)},
+	}
+	for _, test := range tests {
+		GoCmd = test.name
+		if path := gocommand(goroot); path != test.want {
+			t.Errorf("%s: want path = %q, got %q", test.name, test.want, path)
+		}
+	}
+}
+
+// TestExename tests the exename function on unix-like and windows systems.
+func TestExename(t *testing.T) {
+	var tests = []struct {
+		name string
+		goos string
+		want string
+	}{
+		{"go", "linux", "go"},
+		{"go", "darwin", "go"},
+		{"go", "windows", "go.exe"},
+		{"go.exe", "windows", "go.exe"},
+		{"go.bat", "windows", "go.bat"},
+	}
+	for _, test := range tests {
+		if name := exename(test.name, test.goos); name != test.want {
+			t.Errorf("%s on %s: want %q, got %q", test.name, test.goos,
+				test.want, name)
+		}
+	}
+}
+
+// TestFatalErrorRelease tests that fatal invocation errors report the
+// offending release.
+func TestFatalErrorRelease(t *testing.T) {
+	ctx := context.Background()
+	rel := fakeRelease(t, "go1.16", "exit 0")
+	rel.GoRoot = filepath.Join(filepath.Dir(rel.GoRoot), "missing")
+
+	_, err := govet(ctx, rel, Platform{}, []string{"./..."}, Options{})
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if s := err.Error(); !strings.HasPrefix(s, "go1.16 ("+rel.GoRoot+"): ") {
+		t.Errorf("want err reporting the release, got %q", s)
+	}
+	var cmderr *invoke.Error
+	if !errors.As(err, &cmderr) {
+		t.Errorf("expected err as %T, got %T", cmderr, err)
+	}
+
+	_, err = goversion(rel.GoRoot)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if s := err.Error(); !strings.HasPrefix(s, "goroot "+rel.GoRoot+": ") {
+		t.Errorf("want err reporting the goroot, got %q", s)
+	}
+}
+
+// TestChdir tests that the go command runs in the directory specified by
+// opts.Dir.
+func TestChdir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := fakeRelease(t, "go1.16", "pwd >&2; exit 1")
+	opts := Options{Dir: dir}
+
+	msg, err := govet(context.Background(), rel, Platform{}, nil, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if string(msg) != dir {
+		t.Errorf("want msg = %q, got %q", dir, msg)
+	}
+}
+
+// validateNoVet validates the error returned by govet when go vet is not
+// available.
+func validateNoVet(t *testing.T, err error) {
+	t.Helper()
+
+	var skiperr *skipError
+	if !errors.As(err, &skiperr) {
+		t.Fatalf("expected err as %T, got %v", skiperr, err)
+	}
+	if want := "go vet not available"; !strings.HasPrefix(skiperr.reason, want) {
+		t.Errorf("want reason starting with %q, got %q", want, skiperr.reason)
+	}
+	if !strings.Contains(skiperr.reason, "-mode test") {
+		t.Errorf("want reason suggesting -mode test, got %q", skiperr.reason)
+	}
+}
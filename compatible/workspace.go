@@ -0,0 +1,48 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/perillo/go-compatible/version"
+)
+
+// go118 is the first release that supports workspaces.
+var go118 = version.Must(version.Parse("go1.18"))
+
+// FindWorkspace returns the root of the workspace containing dir, that is the
+// nearest directory with a go.work file, starting from dir and walking up the
+// directory tree.  An empty dir means the current directory.  It returns an
+// empty string if dir is not in a workspace.
+func FindWorkspace(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		info, err := os.Stat(filepath.Join(dir, "go.work"))
+		if err == nil && !info.IsDir() {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// noworkspace returns the error reported when the specified release does not
+// support workspaces, or nil.
+func noworkspace(rel Release, opts Options) error {
+	if !opts.Workspace || rel.Devel || !rel.Version.Less(go118) {
+		return nil
+	}
+
+	return &skipError{"go.work not supported"}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package compatible
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFindWorkspace tests that the workspace root is found when go.work is present
+// in a parent directory, and that the go tool is invoked in the workspace
+// root.
+func TestFindWorkspace(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "module", "pkg")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// No workspace.
+	if ws, err := FindWorkspace(dir); err != nil || ws != "" {
+		t.Errorf("want workspace = %q, got %q (%v)", "", ws, err)
+	}
+
+	work := filepath.Join(root, "go.work")
+	if err := os.WriteFile(work, []byte("go 1.18\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ws, err := FindWorkspace(dir)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if ws != root {
+		t.Errorf("want workspace = %q, got %q", root, ws)
+	}
+
+	const script = `pwd >&2; exit 1`
+	rel := fakeRelease(t, "go1.18", script)
+	opts := Options{Dir: ws, Workspace: true}
+	msg, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want, _ := filepath.EvalSymlinks(root)
+	if got, _ := filepath.EvalSymlinks(string(msg)); got != want {
+		t.Errorf("want working directory = %q, got %q", want, got)
+	}
+}
+
+// TestNoWorkspace tests that releases older than go1.18 are skipped in a
+// workspace.
+func TestNoWorkspace(t *testing.T) {
+	rel := fakeRelease(t, "go1.17", "exit 0")
+	opts := Options{Workspace: true}
+
+	var skiperr *skipError
+	_, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, opts)
+	if !errors.As(err, &skiperr) {
+		t.Fatalf("expected err as %T, got %v", skiperr, err)
+	}
+
+	opts.Workspace = false
+	if _, err := govet(context.Background(), rel, Platform{}, []string{"./..."}, opts); err != nil {
+		t.Errorf("expected err == nil, got %q", err)
+	}
+}
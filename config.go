@@ -0,0 +1,99 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defconfig is the configuration file used, if it exists in the current
+// directory, when the -config flag is not set.
+const defconfig = ".go-compatible"
+
+// setting is a key=value line in a configuration file.
+type setting struct {
+	line  int
+	key   string
+	value string
+}
+
+// loadconfig reads the configuration file and sets the flags in fs that were
+// not set on the command line, so that the command line takes precedence.
+// It returns the package patterns specified by the packages key, if any.  An
+// empty file means defconfig, that is ignored when it does not exist.
+func loadconfig(fs *flag.FlagSet, file string) ([]string, error) {
+	name := file
+	if name == "" {
+		name = defconfig
+	}
+	f, err := os.Open(name)
+	if file == "" && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	settings, err := readconfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var patterns []string
+	for _, s := range settings {
+		switch {
+		case s.key == "packages":
+			patterns = append(patterns, strings.Fields(s.value)...)
+		case s.key == "config" || fs.Lookup(s.key) == nil:
+			return nil, fmt.Errorf("%s:%d: unknown flag %s", name, s.line, s.key)
+		case set[s.key]:
+			// The command line takes precedence.
+		default:
+			if err := fs.Set(s.key, s.value); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value %q for flag -%s: %v",
+					name, s.line, s.value, s.key, err)
+			}
+		}
+	}
+
+	return patterns, nil
+}
+
+// readconfig reads the key=value settings from r, one per line.  Empty lines
+// and lines starting with # are ignored.  The key is the name of a flag,
+// without the leading dash.
+func readconfig(r io.Reader) ([]setting, error) {
+	var list []setting
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: setting must be key=value", n)
+		}
+		s := setting{
+			line:  n,
+			key:   strings.TrimSpace(line[:i]),
+			value: strings.TrimSpace(line[i+1:]),
+		}
+		list = append(list, s)
+	}
+
+	return list, sc.Err()
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perillo/go-compatible/version"
+)
+
+// TestLoadConfig tests that the configuration file sets the flags not set on
+// the command line, and that the command line takes precedence.
+func TestLoadConfig(t *testing.T) {
+	const config = `# go-compatible defaults
+mode = test
+timeout=1m
+race = true
+since = 1.18
+packages = ./cmd/... ./internal/...
+`
+	file := writeConfig(t, config)
+
+	var tests = []struct {
+		args    []string
+		mode    string
+		timeout time.Duration
+		race    bool
+		since   string
+	}{
+		{nil, "test", time.Minute, true, "1.18"},
+		{[]string{"-mode", "vet", "-race=false"}, "vet", time.Minute, false, "1.18"},
+		{[]string{"-timeout", "10s", "-since", "go1.20"}, "test", 10 * time.Second,
+			true, "1.20"},
+	}
+	for _, test := range tests {
+		fs, mode, timeout, race, since := newFlagSet()
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.args, err)
+		}
+		patterns, err := loadconfig(fs, file)
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.args, err)
+		}
+
+		want := []string{"./cmd/...", "./internal/..."}
+		if !reflect.DeepEqual(patterns, want) {
+			t.Errorf("%q: want patterns = %q, got %q", test.args, want, patterns)
+		}
+		if *mode != test.mode {
+			t.Errorf("%q: want mode = %q, got %q", test.args, test.mode, *mode)
+		}
+		if *timeout != test.timeout {
+			t.Errorf("%q: want timeout = %v, got %v", test.args, test.timeout,
+				*timeout)
+		}
+		if *race != test.race {
+			t.Errorf("%q: want race = %t, got %t", test.args, test.race, *race)
+		}
+		if s := since.String(); s != test.since {
+			t.Errorf("%q: want since = %q, got %q", test.args, test.since, s)
+		}
+	}
+}
+
+// TestLoadConfigErrors tests that the errors in the configuration file report
+// the line number.
+func TestLoadConfigErrors(t *testing.T) {
+	var tests = []struct {
+		config string
+		want   string
+	}{
+		{"mode = test\nparallel = 4\n", ":2: unknown flag parallel"},
+		{"config = other\n", ":1: unknown flag config"},
+		{"\ntimeout = soon\n", ":2: invalid value \"soon\" for flag -timeout"},
+		{"race\n", ": line 1: setting must be key=value"},
+	}
+	for _, test := range tests {
+		file := writeConfig(t, test.config)
+		fs, _, _, _, _ := newFlagSet()
+		_, err := loadconfig(fs, file)
+		if err == nil {
+			t.Fatalf("%q: expected err != nil", test.config)
+		}
+		if s := err.Error(); !strings.HasPrefix(s, file+test.want) {
+			t.Errorf("%q: want err = %q..., got %q", test.config,
+				file+test.want, s)
+		}
+	}
+
+	// The default configuration file is optional, but an explicit one is
+	// not.
+	dir := t.TempDir()
+	fs, _, _, _, _ := newFlagSet()
+	if _, err := loadconfig(fs, filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected err != nil")
+	}
+	withWorkdir(t, dir)
+	if _, err := loadconfig(fs, ""); err != nil {
+		t.Errorf("expected err == nil, got %q", err)
+	}
+}
+
+// newFlagSet returns a flag set with a subset of the go-compatible flags.
+func newFlagSet() (*flag.FlagSet, *string, *time.Duration, *bool, *version.Version) {
+	fs := flag.NewFlagSet("go-compatible", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	mode := fs.String("mode", "vet", "")
+	timeout := fs.Duration("timeout", 0, "")
+	race := fs.Bool("race", false, "")
+	since := new(version.Version)
+	fs.Var(since, "since", "")
+
+	return fs, mode, timeout, race, since
+}
+
+// writeConfig writes a temporary configuration file and returns its path.
+func writeConfig(t *testing.T, config string) string {
+	file := filepath.Join(t.TempDir(), defconfig)
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// withWorkdir changes the working directory to dir for the duration of the
+// test.
+func withWorkdir(t *testing.T, dir string) {
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
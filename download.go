@@ -0,0 +1,110 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/perillo/go-compatible/internal/invoke"
+	"github.com/perillo/go-compatible/internal/logging"
+	"github.com/perillo/go-compatible/version"
+)
+
+// dlnames parses a comma-separated list of go versions to download, and
+// returns the corresponding release names, like "go1.21.0".  The "go" prefix
+// is optional.  The names are formatted as done by the go command, that is
+// the format used by golang.org/dl, so that 1.21 is go1.21.0 and 1.20.0 is
+// go1.20.
+func dlnames(list string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if !strings.HasPrefix(name, "go") {
+			name = "go" + name
+		}
+		v, err := version.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, "go"+v.String())
+	}
+
+	return names, nil
+}
+
+// download installs the specified releases that are not available in the
+// sdk, using the golang.org/dl wrappers.  A release that can not be
+// installed is skipped with a warning.
+//
+// Note that the golang.org/dl wrappers always install the release in the
+// ~/sdk directory.
+func download(names []string) {
+	var missing []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(gosdk, name)); err == nil {
+			continue
+		}
+		missing = append(missing, name)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	gobin, err := gobindir()
+	if err != nil {
+		logging.Warnf("download: %v", err)
+
+		return
+	}
+	for _, name := range missing {
+		if err := dlrelease(gobin, name); err != nil {
+			logging.Warnf("skipping download of %s: %v", name, err)
+		}
+	}
+}
+
+// dlrelease installs the golang.org/dl wrapper for the named release in
+// gobin, and then invokes it to download the release.
+func dlrelease(gobin, name string) error {
+	// Use the go command installed in the system.
+	cmd := exec.Command("go", "install", "golang.org/dl/"+name+"@latest")
+	if err := invoke.Run(cmd); err != nil {
+		return err
+	}
+
+	cmd = exec.Command(filepath.Join(gobin, name), "download")
+
+	return invoke.Run(cmd)
+}
+
+// gobindir returns the directory where go install installs the commands.
+func gobindir() (string, error) {
+	// Use the go command installed in the system.
+	cmd := exec.Command("go", "env", "GOBIN", "GOPATH")
+	stdout, err := invoke.Output(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	// The output has an empty first line when GOBIN is not set.
+	lines := strings.Split(string(stdout), "\n")
+	if len(lines) == 1 {
+		// The leading empty line was trimmed.
+		lines = []string{"", lines[0]}
+	}
+	if gobin := strings.TrimSpace(lines[0]); gobin != "" {
+		return gobin, nil
+	}
+	gopath := filepath.SplitList(strings.TrimSpace(lines[1]))
+	if len(gopath) == 0 || gopath[0] == "" {
+		return "", fmt.Errorf("unable to determine the GOBIN directory")
+	}
+
+	return filepath.Join(gopath[0], "bin"), nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/perillo/go-compatible/compatible"
+	"github.com/perillo/go-compatible/internal/fakesdk"
+)
+
+// TestDlnames tests the parsing of the list of releases to download, and that
+// the names use the format of golang.org/dl.
+func TestDlnames(t *testing.T) {
+	names, err := dlnames("1.18, go1.21.0,1.22rc1,1.21,go1.20.0")
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want := []string{"go1.18", "go1.21.0", "go1.22rc1", "go1.21.0", "go1.20"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("want names = %q, got %q", want, names)
+	}
+
+	if _, err := dlnames("1.18,foo"); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestDownload tests that the missing releases are downloaded using a stub
+// go command, and that a failure only skips the failing release.
+func TestDownload(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16")
+	withSDK(t, sdk)
+	gobin := t.TempDir()
+	withPath(t, stubGo(t, gobin, sdk))
+
+	download([]string{"go1.16", "go1.17", "go1.99", "go1.18"})
+
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	fakesdk.ValidateReleases(t, releases, []string{"go1.16", "go1.17", "go1.18"})
+
+	// The wrapper for go1.16 must not be installed, since the release is
+	// already available.
+	if _, err := os.Stat(filepath.Join(gobin, "go1.16")); err == nil {
+		t.Error("go1.16: unexpected install")
+	}
+}
+
+// stubGo creates a directory with a stub go command supporting the env and
+// install subcommands, and returns its path.  The installed golang.org/dl
+// wrappers create a fake goroot in sdk, and installing go1.99 fails.
+//
+// stubGo currently only support UNIX systems.
+func stubGo(t *testing.T, gobin, sdk string) string {
+	dir := t.TempDir()
+	script := `case "$1" in
+env)
+	echo "` + gobin + `"
+	echo /nonexistent
+	;;
+install)
+	name=${2#golang.org/dl/}
+	name=${name%@latest}
+	if [ "$name" = go1.99 ]; then
+		echo "$2: not found" >&2
+		exit 1
+	fi
+	cat > "` + gobin + `/$name" <<WRAPPER
+#!/bin/sh
+mkdir -p "` + sdk + `/$name/bin"
+printf '#!/bin/sh\necho go version $name linux/amd64\n' > "` + sdk + `/$name/bin/go"
+chmod +x "` + sdk + `/$name/bin/go"
+WRAPPER
+	chmod +x "` + gobin + `/$name"
+	;;
+esac`
+	fakesdk.Goroot(t, dir, script)
+
+	return filepath.Join(dir, "bin")
+}
+
+// withPath adds dir at the start of the PATH environment variable for the
+// duration of the test.
+func withPath(t *testing.T, dir string) {
+	old := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+old)
+	t.Cleanup(func() { os.Setenv("PATH", old) })
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fakesdk provides the fake sdk directories and goroots used by the
+// tests of go-compatible and of the compatible package.
+//
+// The go command of a fake goroot is a shell script, so fakesdk currently
+// only supports UNIX systems; on Windows the test is skipped.
+package fakesdk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// SDK creates a fake sdk directory with a goroot for each of the specified go
+// versions, and returns its path.  The go command in each goroot only
+// supports the version subcommand.
+func SDK(t *testing.T, goversions ...string) string {
+	t.Helper()
+
+	sdk := t.TempDir()
+	for _, goversion := range goversions {
+		goroot := filepath.Join(sdk, goversion)
+		script := "echo go version " + goversion + " linux/amd64"
+		Goroot(t, goroot, script)
+	}
+
+	return sdk
+}
+
+// Goroot creates a fake goroot with a go command implemented by the
+// specified shell script.
+func Goroot(t *testing.T, goroot, script string) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+	if err := os.MkdirAll(filepath.Join(goroot, "bin"), 0o700); err != nil {
+		t.Fatalf("fakesdk: %v", err)
+	}
+
+	path := filepath.Join(goroot, "bin", "go")
+	code := "#!/bin/sh\n" + script + "\n"
+	if err := os.WriteFile(path, []byte(code), 0o700); err != nil {
+		t.Fatalf("fakesdk: %v", err)
+	}
+}
+
+// ValidateReleases validates that the releases have the specified names, in
+// order.  releases is a slice of releases, like []compatible.Release, and
+// each name is formatted with fmt.Sprint.  The compatible package can not
+// be imported, since its tests use this package.
+func ValidateReleases(t *testing.T, releases interface{}, want []string) {
+	t.Helper()
+
+	v := reflect.ValueOf(releases)
+	got := make([]string, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		got = append(got, fmt.Sprint(v.Index(i).Interface()))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want releases %q, got %q", want, got)
+	}
+}
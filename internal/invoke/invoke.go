@@ -5,28 +5,48 @@
 // Package invoke provides support for invoking a command.  It wraps the
 // standard os/exec package, returning a custom error type that will
 // additionally report the command arguments and the entire content of the
-// invoked command stderr.
+// invoked command stdout and stderr.
 package invoke
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
+
+	"github.com/perillo/go-compatible/internal/logging"
 )
 
+// MaxStderr is the maximum number of bytes of the command stderr retained in
+// Error.  When the command writes more data, only the last MaxStderr bytes
+// are retained, prefixed by a truncation notice.  A value <= 0 means no
+// limit.
+var MaxStderr = 1024 * 1024
+
+// NormalizeCRLF, when true, enables the conversion of the CRLF line endings
+// to LF in the command stdout and stderr, in addition to trimming
+// whitespace, so that the output of commands running on Windows can be
+// parsed by lines.  The Raw variants of the functions and the data written by
+// RunStream while the command is running are never converted, so that binary
+// output is not corrupted.
+var NormalizeCRLF = false
+
 // Error is the error returned when a command returns an error.
 type Error struct {
 	Cmd    string   // the command invoked
 	Argv   []string // arguments to the command
-	Stderr []byte   // the entire content of the command stderr
-	Err    error    // the original error from os/exec.Command.Run
+	Stdout []byte   // the entire content of the command stdout
+	Stderr []byte   // the content of the command stderr, see MaxStderr
+	Err    error    // the original error from os/exec.Command.Run or ctx.Err()
 }
 
 // Error implements the error interface.
 func (e *Error) Error() string {
 	argv := strings.Trim(fmt.Sprint(e.Argv), "[]")
+	stdout := string(e.Stdout)
 	stderr := string(e.Stderr)
 	msg := e.Cmd
 	if argv != "" {
@@ -34,11 +54,75 @@ func (e *Error) Error() string {
 	}
 	msg += ": " + e.Err.Error()
 
-	if stderr == "" {
-		return msg
+	if stdout != "" {
+		msg += ": " + stdout
+	}
+	if stderr != "" {
+		msg += ": " + stderr
+	}
+
+	return msg
+}
+
+// CommandLine returns the command line of the command, with Cmd and Argv
+// quoted as needed so that it can be pasted in a POSIX shell.
+func (e *Error) CommandLine() string {
+	return CommandLine(e.Cmd, e.Argv)
+}
+
+// CommandLine returns the command line of the command name with arguments
+// argv, quoted as needed for a POSIX shell.
+func CommandLine(name string, argv []string) string {
+	words := make([]string, 0, len(argv)+1)
+	words = append(words, Quote(name))
+	for _, arg := range argv {
+		words = append(words, Quote(arg))
 	}
 
-	return msg + ": " + stderr
+	return strings.Join(words, " ")
+}
+
+// Quote returns s quoted for a POSIX shell.  s is returned unchanged when it
+// only contains characters that are not special for the shell.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, c := range s {
+		if !issafe(c) {
+			safe = false
+
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// issafe returns true if c is not special for a POSIX shell.
+func issafe(c rune) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+
+	return strings.ContainsRune("@%+=:,./_-", c)
+}
+
+// ExitCode returns the exit code of the command, when Err is an
+// *exec.ExitError, or -1 otherwise.
+func (e *Error) ExitCode() int {
+	var eerr *exec.ExitError
+	if errors.As(e.Err, &eerr) {
+		return eerr.ExitCode()
+	}
+
+	return -1
 }
 
 // Unwrap implements the Wrapper interface.
@@ -49,16 +133,36 @@ func (e *Error) Unwrap() error {
 // Run runs cmd.
 //
 // In case the command exits with a non 0 exit status, the error will contain
-// the entire content of the command stderr, with whitespace trimmed.
+// the entire content of the command stdout and the content of the command
+// stderr, with whitespace trimmed.
 func Run(cmd *exec.Cmd) error {
-	stderr := new(bytes.Buffer)
+	return RunContext(context.Background(), cmd)
+}
+
+// RunContext is like Run but includes a context.
+//
+// The provided context is used to kill the process (by calling
+// os.Process.Kill) if the context becomes done before the command completes
+// on its own, as done by exec.CommandContext.  On UNIX systems the command
+// runs in its own process group and the whole group is killed, including
+// the processes started by the command.  In this case the error will
+// wrap ctx.Err().
+func RunContext(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd.Stdout != nil {
+		return errors.New("invoke: Stdout already set")
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := &tailBuffer{max: MaxStderr}
+	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := run(ctx, cmd); err != nil {
 		err := &Error{
 			Cmd:    cmd.Path,
 			Argv:   cmd.Args[1:],
-			Stderr: normalize(stderr),
+			Stdout: normalize(stdout.Bytes()),
+			Stderr: stderr.Tail(),
 			Err:    err,
 		}
 
@@ -71,33 +175,261 @@ func Run(cmd *exec.Cmd) error {
 // Output invokes cmd and returns the stdout content, with whitespace trimmed.
 //
 // In case the command exits with a non 0 exit status, the error will contain
-// the entire content of the command stderr, with whitespace trimmed.
+// the content of the command stderr, with whitespace trimmed.
 func Output(cmd *exec.Cmd) ([]byte, error) {
+	return OutputContext(context.Background(), cmd)
+}
+
+// OutputContext is like Output but includes a context.
+//
+// The provided context is used to kill the process (by calling
+// os.Process.Kill) if the context becomes done before the command completes
+// on its own, as done by exec.CommandContext.  On UNIX systems the command
+// runs in its own process group and the whole group is killed, including
+// the processes started by the command.  In this case the error will
+// wrap ctx.Err().
+func OutputContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	return output(ctx, cmd, normalize)
+}
+
+// RawOutput is like Output, but the stdout content, and the command stdout and
+// stderr in the error, are returned unchanged, without trimming whitespace.
+func RawOutput(cmd *exec.Cmd) ([]byte, error) {
+	return RawOutputContext(context.Background(), cmd)
+}
+
+// RawOutputContext is like RawOutput but includes a context, as described in
+// OutputContext.
+func RawOutputContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	return output(ctx, cmd, raw)
+}
+
+// output implements OutputContext and RawOutputContext, using clean to
+// process the command stdout and stderr.
+func output(ctx context.Context, cmd *exec.Cmd, clean func([]byte) []byte) ([]byte, error) {
 	if cmd.Stdout != nil {
 		return nil, errors.New("invoke: Stdout already set")
 	}
 
 	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
+	stderr := &tailBuffer{max: MaxStderr}
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := run(ctx, cmd); err != nil {
+		err := &Error{
+			Cmd:    cmd.Path,
+			Argv:   cmd.Args[1:],
+			Stdout: clean(stdout.Bytes()),
+			Stderr: stderr.tail(clean),
+			Err:    err,
+		}
+
+		return clean(stdout.Bytes()), err
+	}
+
+	return clean(stdout.Bytes()), nil
+}
+
+// CombinedOutput invokes cmd and returns the combined stdout and stderr
+// content, with whitespace trimmed.  The relative order of the data written on
+// stdout and stderr is preserved.
+//
+// In case the command exits with a non 0 exit status, the error will contain
+// the combined content in Stdout, with whitespace trimmed.
+func CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	return CombinedOutputContext(context.Background(), cmd)
+}
+
+// CombinedOutputContext is like CombinedOutput but includes a context.
+//
+// The provided context is used to kill the process (by calling
+// os.Process.Kill) if the context becomes done before the command completes
+// on its own, as done by exec.CommandContext.  On UNIX systems the command
+// runs in its own process group and the whole group is killed, including
+// the processes started by the command.  In this case the error will
+// wrap ctx.Err().
+func CombinedOutputContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	return combinedoutput(ctx, cmd, normalize)
+}
+
+// RawCombinedOutput is like CombinedOutput, but the combined content is
+// returned unchanged, without trimming whitespace.
+func RawCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	return RawCombinedOutputContext(context.Background(), cmd)
+}
+
+// RawCombinedOutputContext is like RawCombinedOutput but includes a context,
+// as described in CombinedOutputContext.
+func RawCombinedOutputContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	return combinedoutput(ctx, cmd, raw)
+}
+
+// combinedoutput implements CombinedOutputContext and
+// RawCombinedOutputContext, using clean to process the combined content.
+func combinedoutput(ctx context.Context, cmd *exec.Cmd, clean func([]byte) []byte) ([]byte, error) {
+	if cmd.Stdout != nil {
+		return nil, errors.New("invoke: Stdout already set")
+	}
+	if cmd.Stderr != nil {
+		return nil, errors.New("invoke: Stderr already set")
+	}
+
+	// Using the same writer for stdout and stderr, the command will use the
+	// same file descriptor, preserving the order of the writes.
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := run(ctx, cmd); err != nil {
 		err := &Error{
 			Cmd:    cmd.Path,
 			Argv:   cmd.Args[1:],
-			Stderr: normalize(stderr),
+			Stdout: clean(output.Bytes()),
 			Err:    err,
 		}
 
-		return normalize(stdout), err
+		return clean(output.Bytes()), err
 	}
 
-	return normalize(stdout), nil
+	return clean(output.Bytes()), nil
 }
 
-// normalize returns the data buffered in b with leading and trailing white
-// space removed.
-func normalize(b *bytes.Buffer) []byte {
-	return bytes.TrimSpace(b.Bytes())
+// tailSize is the maximum number of bytes of the command stdout retained by
+// RunStream.
+const tailSize = 64 * 1024
+
+// RunStream runs cmd, writing the command stdout to out and the command
+// stderr to errw while the command is running.
+//
+// In case the command exits with a non 0 exit status, the error will contain
+// the last 64 KiB of the command stdout and the content of the command
+// stderr, with whitespace trimmed.
+func RunStream(cmd *exec.Cmd, out, errw io.Writer) error {
+	stdout := &tailBuffer{max: tailSize}
+	stderr := &tailBuffer{max: MaxStderr}
+	cmd.Stdout = io.MultiWriter(out, stdout)
+	cmd.Stderr = io.MultiWriter(errw, stderr)
+
+	if err := run(context.Background(), cmd); err != nil {
+		err := &Error{
+			Cmd:    cmd.Path,
+			Argv:   cmd.Args[1:],
+			Stdout: stdout.Tail(),
+			Stderr: stderr.Tail(),
+			Err:    err,
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// run starts cmd and waits for it to complete, killing the process if ctx
+// becomes done before.  The command is started in its own process group only
+// when ctx can be done, so that otherwise it still receives the signals from
+// the terminal, like SIGINT.
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if logging.Enabled(logging.Debug) {
+		logging.Debugf("run %s", CommandLine(cmd.Path, cmd.Args[1:]))
+	}
+	if ctx.Done() != nil {
+		setpgid(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(cmd)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	if err != nil && ctx.Err() != nil {
+		// The process was probably killed.
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// normalize returns data with leading and trailing white space removed and,
+// if NormalizeCRLF is set, the CRLF line endings converted to LF.
+func normalize(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if NormalizeCRLF {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+
+	return data
+}
+
+// raw returns data unchanged.
+func raw(data []byte) []byte {
+	return data
+}
+
+// tailBuffer is an io.Writer that only retains the last max bytes written to
+// it.  A max <= 0 means no limit.
+type tailBuffer struct {
+	max     int
+	buf     []byte
+	dropped int64 // number of bytes discarded
+}
+
+// Write implements the Writer interface.
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max <= 0 {
+		b.buf = append(b.buf, p...)
+
+		return n, nil
+	}
+	if n >= b.max {
+		b.dropped += int64(len(b.buf) + n - b.max)
+		b.buf = append(b.buf[:0], p[n-b.max:]...)
+
+		return n, nil
+	}
+	if drop := len(b.buf) + n - b.max; drop > 0 {
+		// Discard the oldest data, reusing the buffer memory.
+		copy(b.buf, b.buf[drop:])
+		b.buf = b.buf[:len(b.buf)-drop]
+		b.dropped += int64(drop)
+	}
+	b.buf = append(b.buf, p...)
+
+	return n, nil
+}
+
+// Bytes returns the data retained by b.
+func (b *tailBuffer) Bytes() []byte {
+	return b.buf
+}
+
+// Tail returns the data retained by b with whitespace trimmed, prefixed by a
+// truncation notice if some data was discarded.
+func (b *tailBuffer) Tail() []byte {
+	return b.tail(normalize)
+}
+
+// tail is like Tail, but uses clean to process the retained data.
+func (b *tailBuffer) tail(clean func([]byte) []byte) []byte {
+	data := clean(b.buf)
+	if b.dropped == 0 {
+		return data
+	}
+	notice := fmt.Sprintf("[%d bytes truncated]\n", b.dropped)
+
+	return append([]byte(notice), data...)
 }
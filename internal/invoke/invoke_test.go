@@ -5,16 +5,23 @@
 package invoke
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestRun tests the Run function by executing a temporary shell script.
 func TestRun(t *testing.T) {
+	const stdout = "hello stdout"
 	const stderr = "hello stderr"
 
 	name := tempScript(t)
@@ -25,7 +32,7 @@ func TestRun(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected err != nil")
 	}
-	validate(t, err, name, argv, stderr)
+	validate(t, err, name, argv, stdout, stderr)
 }
 
 // TestOutput tests the Output function by executing a temporary shell script.
@@ -44,11 +51,294 @@ func TestOutput(t *testing.T) {
 	if string(data) != stdout {
 		t.Errorf("want data = %s, got %s", stdout, data)
 	}
-	validate(t, err, name, argv, stderr)
+	validate(t, err, name, argv, stdout, stderr)
+}
+
+// TestRawOutput tests that the RawOutput and RawCombinedOutput functions
+// return the same output as Output and CombinedOutput, without trimming
+// whitespace.
+func TestRawOutput(t *testing.T) {
+	name := writeScript(t, "space.sh", `printf "\n  out\n\n"
+printf "\terr\n" >&2
+exit 1`)
+
+	data, err := Output(exec.Command(name))
+	if want := "out"; string(data) != want {
+		t.Errorf("Output: want data = %q, got %q", want, data)
+	}
+	if e := err.(*Error); string(e.Stderr) != "err" {
+		t.Errorf("Output: want e.Stderr = %q, got %q", "err", e.Stderr)
+	}
+	data, err = RawOutput(exec.Command(name))
+	if want := "\n  out\n\n"; string(data) != want {
+		t.Errorf("RawOutput: want data = %q, got %q", want, data)
+	}
+	e := err.(*Error)
+	if want := "\n  out\n\n"; string(e.Stdout) != want {
+		t.Errorf("RawOutput: want e.Stdout = %q, got %q", want, e.Stdout)
+	}
+	if want := "\terr\n"; string(e.Stderr) != want {
+		t.Errorf("RawOutput: want e.Stderr = %q, got %q", want, e.Stderr)
+	}
+
+	data, _ = CombinedOutput(exec.Command(name))
+	if want := "out\n\n\terr"; string(data) != want {
+		t.Errorf("CombinedOutput: want data = %q, got %q", want, data)
+	}
+	data, _ = RawCombinedOutput(exec.Command(name))
+	if want := "\n  out\n\n\terr\n"; string(data) != want {
+		t.Errorf("RawCombinedOutput: want data = %q, got %q", want, data)
+	}
+}
+
+// TestNormalizeCRLF tests that the CRLF line endings are converted only when
+// NormalizeCRLF is set, and never by the Raw variants.
+func TestNormalizeCRLF(t *testing.T) {
+	name := writeScript(t, "crlf.sh", `printf "line 1\r\nline 2\r\n"
+printf "err 1\r\nerr 2\r\n" >&2
+exit 1`)
+
+	data, _ := Output(exec.Command(name))
+	if want := "line 1\r\nline 2"; string(data) != want {
+		t.Errorf("Output: want data = %q, got %q", want, data)
+	}
+
+	defer func() {
+		NormalizeCRLF = false
+	}()
+	NormalizeCRLF = true
+
+	data, err := Output(exec.Command(name))
+	if want := "line 1\nline 2"; string(data) != want {
+		t.Errorf("Output: want data = %q, got %q", want, data)
+	}
+	if e := err.(*Error); string(e.Stderr) != "err 1\nerr 2" {
+		t.Errorf("Output: want e.Stderr = %q, got %q", "err 1\nerr 2", e.Stderr)
+	}
+	data, _ = CombinedOutput(exec.Command(name))
+	if want := "line 1\nline 2\nerr 1\nerr 2"; string(data) != want {
+		t.Errorf("CombinedOutput: want data = %q, got %q", want, data)
+	}
+	data, _ = RawOutput(exec.Command(name))
+	if want := "line 1\r\nline 2\r\n"; string(data) != want {
+		t.Errorf("RawOutput: want data = %q, got %q", want, data)
+	}
+}
+
+// TestRunStream tests the RunStream function by executing a temporary shell
+// script, checking that the output is both streamed and captured.
+func TestRunStream(t *testing.T) {
+	const stdout = "hello stdout"
+	const stderr = "hello stderr"
+
+	name := tempScript(t)
+	argv := []string{"-a", "b"}
+	cmd := exec.Command(name, argv...)
+
+	out := new(bytes.Buffer)
+	errw := new(bytes.Buffer)
+	err := RunStream(cmd, out, errw)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if want := "\t" + stdout + newline(); out.String() != want {
+		t.Errorf("want out = %q, got %q", want, out)
+	}
+	if want := "\t" + stderr + newline(); errw.String() != want {
+		t.Errorf("want errw = %q, got %q", want, errw)
+	}
+	validate(t, err, name, argv, stdout, stderr)
+}
+
+// TestCombinedOutput tests that the CombinedOutput function preserves the
+// order of the data written on stdout and stderr.
+func TestCombinedOutput(t *testing.T) {
+	name := writeScript(t, "interleave.sh", `echo out1
+echo err1 >&2
+echo out2
+echo err2 >&2
+exit 1`)
+	cmd := exec.Command(name)
+
+	data, err := CombinedOutput(cmd)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	const want = "out1\nerr1\nout2\nerr2"
+	if string(data) != want {
+		t.Errorf("want data = %q, got %q", want, data)
+	}
+	if e := err.(*Error); string(e.Stdout) != want {
+		t.Errorf("want e.Stdout = %q, got %q", want, e.Stdout)
+	}
+
+	// A successful command.
+	name = writeScript(t, "ok.sh", "echo out; echo err >&2")
+	data, err = CombinedOutput(exec.Command(name))
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if want := "out\nerr"; string(data) != want {
+		t.Errorf("want data = %q, got %q", want, data)
+	}
+}
+
+// TestTailBuffer tests that tailBuffer only retains the last bytes written.
+func TestTailBuffer(t *testing.T) {
+	var tests = []struct {
+		writes []string
+		want   string
+	}{
+		{[]string{"abc"}, "abc"},
+		{[]string{"abcd"}, "abcd"},
+		{[]string{"abcdef"}, "cdef"},
+		{[]string{"ab", "cd", "ef"}, "cdef"},
+		{[]string{"a", "bcdefgh"}, "efgh"},
+	}
+	for _, test := range tests {
+		b := &tailBuffer{max: 4}
+		for _, s := range test.writes {
+			b.Write([]byte(s))
+		}
+		if got := string(b.Bytes()); got != test.want {
+			t.Errorf("%q: want %q, got %q", test.writes, test.want, got)
+		}
+	}
+}
+
+// TestRunMaxStderr tests that Run only retains the last MaxStderr bytes of a
+// large stderr, prefixed by a truncation notice.
+func TestRunMaxStderr(t *testing.T) {
+	const size = 100000
+	const max = 1000
+
+	defer func(n int) { MaxStderr = n }(MaxStderr)
+	MaxStderr = max
+
+	name := writeScript(t, "large.sh",
+		"head -c 100000 /dev/zero | tr '\\0' x >&2; echo end >&2; exit 1")
+	cmd := exec.Command(name)
+
+	err := Run(cmd)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	e := err.(*Error)
+
+	// The script writes size + len("end\n") bytes.
+	notice := fmt.Sprintf("[%d bytes truncated]\n", size+4-max)
+	want := notice + strings.Repeat("x", max-4) + "end"
+	if s := string(e.Stderr); s != want {
+		t.Errorf("want e.Stderr = %.40q... (%d bytes), got %.40q... (%d bytes)",
+			want, len(want), s, len(s))
+	}
+}
+
+// TestExitCode tests the Error.ExitCode method.
+func TestExitCode(t *testing.T) {
+	t.Run("exit status 1", func(t *testing.T) {
+		name := tempScript(t)
+		validateExitCode(t, exec.Command(name), 1)
+	})
+
+	t.Run("exit status 3", func(t *testing.T) {
+		name := writeScript(t, "exit.sh", "exit 3")
+		validateExitCode(t, exec.Command(name), 3)
+	})
+
+	t.Run("exec error", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "missing")
+		validateExitCode(t, exec.Command(name), -1)
+	})
+}
+
+// validateExitCode validates the exit code reported by the error returned by
+// Run.
+func validateExitCode(t *testing.T, cmd *exec.Cmd, code int) {
+	err := Run(cmd)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if c := err.(*Error).ExitCode(); c != code {
+		t.Errorf("want exit code %d, got %d", code, c)
+	}
+}
+
+// TestCommandLine tests that the Error.CommandLine method quotes the
+// arguments with spaces and special characters.
+func TestCommandLine(t *testing.T) {
+	var tests = []struct {
+		cmd  string
+		argv []string
+		want string
+	}{
+		{"go", nil, "go"},
+		{"go", []string{"vet", "./..."}, "go vet ./..."},
+		{"/usr/local/go/bin/go", []string{"test", "-run=TestX"},
+			"/usr/local/go/bin/go test -run=TestX"},
+		{"go", []string{"test", "-run", "Test A"}, "go test -run 'Test A'"},
+		{"go", []string{""}, "go ''"},
+		{"go", []string{"it's"}, `go 'it'\''s'`},
+		{"go", []string{"$HOME", "a;b", "*", `\`}, `go '$HOME' 'a;b' '*' '\'`},
+		{"/my dir/go", []string{"env"}, "'/my dir/go' env"},
+	}
+	for _, test := range tests {
+		e := &Error{Cmd: test.cmd, Argv: test.argv}
+		if s := e.CommandLine(); s != test.want {
+			t.Errorf("%q %q: want %q, got %q", test.cmd, test.argv, test.want,
+				s)
+		}
+	}
+}
+
+// TestRunContext tests that the RunContext function kills the process when
+// the context is canceled.
+func TestRunContext(t *testing.T) {
+	name := sleepScript(t)
+	cmd := exec.Command(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := RunContext(ctx, cmd)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected err to wrap %v, got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RunContext returned after %v", d)
+	}
+}
+
+// TestRunContextChild tests that the RunContext function also kills the
+// processes started by the command, that would otherwise keep the stdout and
+// stderr pipes open after the command is killed.
+func TestRunContextChild(t *testing.T) {
+	// The shell does not exec sleep, since it is not the last command.
+	name := writeScript(t, "child.sh", "sleep 10\necho done")
+	cmd := exec.Command(name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := RunContext(ctx, cmd)
+	if err == nil {
+		t.Fatal("expected err != nil")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected err to wrap %v, got %v", context.DeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("RunContext returned after %v", d)
+	}
 }
 
 // validate validates the error returned by Run or Output.
-func validate(t *testing.T, err error, name string, argv []string, stderr string) {
+func validate(t *testing.T, err error, name string, argv []string, stdout, stderr string) {
 	var eerr *exec.ExitError
 
 	e := err.(*Error)
@@ -61,28 +351,82 @@ func validate(t *testing.T, err error, name string, argv []string, stderr string
 	if !reflect.DeepEqual(e.Argv, argv) {
 		t.Errorf("want e.Argv = %q, got %q", argv, e.Argv)
 	}
+	if string(e.Stdout) != stdout {
+		t.Errorf("want e.Stdout = %s, got %s", stdout, e.Stdout)
+	}
 	if string(e.Stderr) != stderr {
 		t.Errorf("want e.Stderr = %s, got %s", stderr, e.Stderr)
 	}
+
+	want := name + " " + strings.Join(argv, " ") + ": " + e.Err.Error() +
+		": " + stdout + ": " + stderr
+	if msg := e.Error(); msg != want {
+		t.Errorf("want e.Error() = %q, got %q", want, msg)
+	}
 }
 
-// tempScript creates a temporary shell script that writes "hello stdout" on
-// stdout and "hello stderr" on stderr with additional whitespace, and exits
-// with exit status 1.
+// tempScript creates a temporary script that writes "hello stdout" on stdout
+// and "hello stderr" on stderr with additional whitespace, and exits with exit
+// status 1.
 //
-// tempScript currently only support UNIX systems.
+// On Windows the script is a batch file, otherwise it is a shell script.
 func tempScript(t *testing.T) string {
+	const code = `printf "\thello stdout\n" >&1
+printf "\thello stderr\n" >&2
+exit 1`
+	const wincode = "@echo off\r\n" +
+		"echo \thello stdout\r\n" +
+		">&2 echo \thello stderr\r\n" +
+		"exit /b 1\r\n"
+
+	return script(t, "test", code, wincode)
+}
+
+// sleepScript creates a temporary shell script that sleeps for 10 seconds.
+//
+// sleepScript currently only support UNIX systems.
+func sleepScript(t *testing.T) string {
+	return writeScript(t, "sleep.sh", "exec sleep 10")
+}
+
+// writeScript creates a temporary shell script with the specified name,
+// executing code.
+//
+// writeScript currently only support UNIX systems; on Windows the test is
+// skipped.
+func writeScript(t *testing.T, name, code string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on windows")
+	}
+
+	return script(t, name, code, "")
+}
+
+// script creates a temporary script with the specified name and returns its
+// path.  On Windows the script is a batch file with the .bat extension,
+// executing wincode, otherwise it is a shell script executing code.
+func script(t *testing.T, name, code, wincode string) string {
 	dir := t.TempDir()
-	path := filepath.Join(dir, "test.sh")
+	path := filepath.Join(dir, name)
+	if runtime.GOOS == "windows" {
+		path += ".bat"
+		code = wincode
+	} else {
+		code = "#!/bin/sh\n" + code + "\n"
+	}
 
-	code := `#!/bin/sh
-printf "\thello stdout\n" >&1
-printf "\thello stderr\n" >&2
-exit 1
-`
 	if err := os.WriteFile(path, []byte(code), 0o700); err != nil {
-		t.Fatalf("tempscript: %v", err)
+		t.Fatalf("script: %v", err)
 	}
 
 	return path
 }
+
+// newline is the line terminator written by the scripts created by script.
+func newline() string {
+	if runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+
+	return "\n"
+}
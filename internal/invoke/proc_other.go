@@ -0,0 +1,19 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package invoke
+
+import "os/exec"
+
+// setpgid does nothing, since process groups are not supported.
+func setpgid(cmd *exec.Cmd) {}
+
+// kill kills the process of cmd.  The processes started by the command are
+// not killed.
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
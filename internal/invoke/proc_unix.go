@@ -0,0 +1,34 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package invoke
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setpgid configures cmd to start in its own process group, so that kill
+// can also kill the processes started by the command.
+func setpgid(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// kill kills the process group of cmd, including the processes started by
+// the command, like the test binaries started by go test.  Otherwise they
+// would keep the stdout and stderr pipes open, and cmd.Wait would block
+// until they exit.
+func kill(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+
+	return nil
+}
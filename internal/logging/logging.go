@@ -0,0 +1,99 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package logging provides leveled logging on top of the standard log
+// package.  Each message is written with log.Print, prefixed by its level
+// name, only when its level is enabled.
+//
+// The default level is Warn, so that the output is the same as using
+// log.Printf with a "warning: " prefix for warnings.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level is the severity of a log message.
+type Level int32
+
+// The supported levels, in order of increasing verbosity.
+const (
+	Error Level = iota // only fatal errors are logged
+	Warn               // warnings, like a skipped release
+	Info               // the progress of the main operations
+	Debug              // diagnostics, like the commands invoked
+)
+
+// levelnames maps each level to its name, as accepted by Level.Set.
+var levelnames = []string{
+	Error: "error",
+	Warn:  "warn",
+	Info:  "info",
+	Debug: "debug",
+}
+
+// String implements the Stringer interface.
+func (l Level) String() string {
+	if l < Error || l > Debug {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+
+	return levelnames[l]
+}
+
+// Set implements the Value interface.
+func (l *Level) Set(s string) error {
+	for i, name := range levelnames {
+		if s == name {
+			*l = Level(i)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("must be \"error\", \"warn\", \"info\" or \"debug\"")
+}
+
+// level is the current level, accessed atomically since messages may be
+// logged concurrently.
+var level = int32(Warn)
+
+// SetLevel sets the current level.  Messages more verbose than l are
+// discarded.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// Enabled returns true if messages with level l are logged.  It can be used
+// to avoid the cost of formatting a message that would be discarded.
+func Enabled(l Level) bool {
+	return l <= Level(atomic.LoadInt32(&level))
+}
+
+// Warnf logs a warning, with the "warning: " prefix.
+func Warnf(format string, args ...interface{}) {
+	logf(Warn, "warning: ", format, args)
+}
+
+// Infof logs an informational message, with the "info: " prefix.
+func Infof(format string, args ...interface{}) {
+	logf(Info, "info: ", format, args)
+}
+
+// Debugf logs a diagnostic message, with the "debug: " prefix.
+func Debugf(format string, args ...interface{}) {
+	logf(Debug, "debug: ", format, args)
+}
+
+// logf logs the message with the specified level and prefix, if the level
+// is enabled.
+func logf(l Level, prefix, format string, args []interface{}) {
+	if !Enabled(l) {
+		return
+	}
+
+	log.Print(prefix + fmt.Sprintf(format, args...))
+}
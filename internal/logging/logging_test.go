@@ -0,0 +1,64 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+)
+
+// TestLevels tests that only the messages up to the current level are
+// logged, and that debug messages are logged only at the debug level.
+func TestLevels(t *testing.T) {
+	var tests = []struct {
+		level Level
+		want  string
+	}{
+		{Error, ""},
+		{Warn, "warning: w 1\n"},
+		{Info, "warning: w 1\ninfo: i 2\n"},
+		{Debug, "warning: w 1\ninfo: i 2\ndebug: d 3\n"},
+	}
+
+	buf := new(bytes.Buffer)
+	defer func(w io.Writer, flags int) {
+		log.SetOutput(w)
+		log.SetFlags(flags)
+		SetLevel(Warn)
+	}(log.Writer(), log.Flags())
+	log.SetOutput(buf)
+	log.SetFlags(0)
+
+	for _, test := range tests {
+		buf.Reset()
+		SetLevel(test.level)
+		Warnf("w %d", 1)
+		Infof("i %d", 2)
+		Debugf("d %d", 3)
+		if s := buf.String(); s != test.want {
+			t.Errorf("%s: want output = %q, got %q", test.level, test.want, s)
+		}
+	}
+}
+
+// TestLevelSet tests the Level flag.Value implementation.
+func TestLevelSet(t *testing.T) {
+	for _, name := range []string{"error", "warn", "info", "debug"} {
+		var l Level
+		if err := l.Set(name); err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", name, err)
+		}
+		if s := l.String(); s != name {
+			t.Errorf("want level = %q, got %q", name, s)
+		}
+	}
+
+	var l Level
+	if err := l.Set("warning"); err == nil {
+		t.Error("expected err != nil")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/perillo/go-compatible/compatible"
+)
+
+// junitsuites is the root element of a JUnit XML report.
+type junitsuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitsuite `xml:"testsuite"`
+}
+
+// junitsuite is a test suite in a JUnit XML report, with a test case for each
+// release and platform.
+type junitsuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitcase `xml:"testcase"`
+}
+
+// junitcase is the test case of a release and platform.
+type junitcase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitmessage `xml:"failure,omitempty"`
+	Skipped   *junitmessage `xml:"skipped,omitempty"`
+}
+
+// junitmessage is the failure or skip of a test case, with the reason in the
+// message attribute and the diagnostic message in the content.
+type junitmessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writejunit writes the results to file as a JUnit XML report.
+func writejunit(file string, results []compatible.Result) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := junit(f, results); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// junit writes the results to w as a JUnit XML report, with a test suite
+// named go-compatible.  Each release and platform is a test case, named with
+// the version of the release and with the platform as the class name.
+func junit(w io.Writer, results []compatible.Result) error {
+	counts := compatible.Count(results)
+	suite := junitsuite{
+		Name:     "go-compatible",
+		Tests:    counts.Total,
+		Failures: counts.Failed,
+		Skipped:  counts.Skipped,
+	}
+	var total time.Duration
+	for _, res := range results {
+		name := res.Release.Version.String()
+		if res.Release.Devel {
+			name = res.Release.String()
+		}
+		tc := junitcase{
+			Name:      name,
+			ClassName: res.Platform.String(),
+			Time:      junittime(res.Duration),
+		}
+		msg := &junitmessage{Message: reason(res), Text: string(res.Msg)}
+		switch res.Status {
+		case compatible.Fail:
+			tc.Failure = msg
+		case compatible.Skip:
+			tc.Skipped = msg
+		}
+		suite.Cases = append(suite.Cases, tc)
+		total += res.Duration
+	}
+	suite.Time = junittime(total)
+
+	data, err := xml.MarshalIndent(junitsuites{Suites: []junitsuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+
+	return err
+}
+
+// junittime formats d in seconds, as used by JUnit XML reports.
+func junittime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
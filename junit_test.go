@@ -0,0 +1,97 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perillo/go-compatible/compatible"
+)
+
+// TestJUnit tests that the JUnit XML report parses, with a test case for each
+// release and the failures and skips recorded.
+func TestJUnit(t *testing.T) {
+	results := []compatible.Result{
+		newresult("go1.0", compatible.Skip, "race detector not supported", ""),
+		newresult("go1.15", compatible.Fail, "diagnostics found", "./pkg.go:3:2: bad <format>"),
+		newresult("go1.16", compatible.Pass, "", ""),
+		newresult("go1.21.0", compatible.Fail, "timeout", "timeout: killed after 1m0s"),
+	}
+	setduration(results, 1500*time.Millisecond)
+
+	file := filepath.Join(t.TempDir(), "report.xml")
+	if err := writejunit(file, results); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(xml.Header)) {
+		t.Errorf("want the XML header, got %q", data)
+	}
+
+	var report junitsuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("want 1 test suite, got %d", len(report.Suites))
+	}
+	suite := report.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 2 || suite.Skipped != 1 {
+		t.Errorf("want 4 tests, 2 failures, 1 skipped, got %d, %d, %d",
+			suite.Tests, suite.Failures, suite.Skipped)
+	}
+	if suite.Time != "6.000" {
+		t.Errorf("want suite time = %q, got %q", "6.000", suite.Time)
+	}
+	if len(suite.Cases) != 4 {
+		t.Fatalf("want 4 test cases, got %d", len(suite.Cases))
+	}
+
+	var tests = []struct {
+		name    string
+		failure string
+		skipped string
+	}{
+		{"1.0", "", "race detector not supported"},
+		{"1.15", "diagnostics found", ""},
+		{"1.16", "", ""},
+		{"1.21.0", "timeout", ""},
+	}
+	for i, test := range tests {
+		tc := suite.Cases[i]
+		if tc.Name != test.name {
+			t.Errorf("want test case %d name = %q, got %q", i, test.name, tc.Name)
+		}
+		if tc.Time != "1.500" {
+			t.Errorf("%s: want time = %q, got %q", tc.Name, "1.500", tc.Time)
+		}
+		if s := message(tc.Failure); s != test.failure {
+			t.Errorf("%s: want failure = %q, got %q", tc.Name, test.failure, s)
+		}
+		if s := message(tc.Skipped); s != test.skipped {
+			t.Errorf("%s: want skipped = %q, got %q", tc.Name, test.skipped, s)
+		}
+	}
+	if s := suite.Cases[1].Failure.Text; s != "./pkg.go:3:2: bad <format>" {
+		t.Errorf("want failure text = %q, got %q", "./pkg.go:3:2: bad <format>", s)
+	}
+}
+
+// message returns the message of m, or an empty string if m is nil.
+func message(m *junitmessage) string {
+	if m == nil {
+		return ""
+	}
+
+	return m.Message
+}
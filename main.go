@@ -9,57 +9,312 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/perillo/go-compatible/compatible"
 	"github.com/perillo/go-compatible/internal/invoke"
-	"github.com/perillo/go-compatible/internal/version"
+	"github.com/perillo/go-compatible/internal/logging"
+	"github.com/perillo/go-compatible/version"
 )
 
 // gosdk is the path to go sdk directory, by default ~/sdk.  It can be
-// overridden using the GOSDK environment variable.
+// overridden using the GOSDK environment variable or the -sdk flag.
 var gosdk string
 
 // Flags.
 var (
-	mode  = flag.String("mode", "vet", "verification mode (vet, build or test)")
-	since version.Version
+	mode       = flag.String("mode", "vet", "verification mode (vet, build, test or auto)")
+	timeout    = flag.Duration("timeout", 0, "kill the tool after the specified duration, for each release (0 means no timeout)")
+	pkgtimeout = flag.Duration("timeout-per-package", 0, "pass -timeout to go test in test mode, to panic a test binary running longer than the duration (0 means the go test default)")
+	goos       = flag.String("goos", "", "comma-separated list of target operating systems")
+	goarch     = flag.String("goarch", "", "comma-separated list of target architectures")
+	race       = flag.Bool("race", false, "enable the race detector in test mode")
+	shuffle    = flag.String("shuffle", "", "pass -shuffle to go test in test mode (on, off or a seed), omitting it for releases before go1.17")
+	list       = flag.Bool("list", false, "print the releases that would be used and exit")
+	printenvf  = flag.Bool("print-env", false, "print the environment variables set for the go tool, for each release and platform, and exit")
+	checksdk   = flag.Bool("check-sdk", false, "check all the goroots in the sdk directory, report the problems and exit")
+	dryrun     = flag.Bool("n", false, "print the commands that would be executed, without running them")
+	summary    = flag.Bool("summary", false, "print a summary of the results at the end of the run")
+	latest     = flag.Int("latest", 0, "use only the N most recent releases (0 means all)")
+	minor      = flag.Bool("minor-only", false, "use only the most recent release of each minor version")
+	desc       = flag.Bool("desc", false, "use the releases from the most recent to the oldest")
+	sincepre   = flag.Bool("since-prereleases", false, "include the pre-releases of the -since version, like go1.21rc1 with -since 1.21")
+	stable     = flag.Bool("stable", false, "exclude pre-releases")
+	verlist    = flag.String("versions", "", "comma-separated list of the exact releases to use, like \"1.18,1.20.3\", bypassing the other release filters")
+	match      = flag.String("match", "", "use only releases with a version matching a regular expression, like \"^1\\.21\"")
+	ignore     = flag.String("ignore", "", "remove the diagnostic lines matching a regular expression, like \"is deprecated\"; a release with only ignored lines passes")
+	dllist     = flag.String("download", "", "comma-separated list of releases to download, if missing")
+	chdir      = flag.String("C", "", "run the go tool in the specified directory")
+	tags       = flag.String("tags", "", "comma-separated list of build tags passed to the go tool")
+	verbose    = flag.Bool("verbose", false, "include the goroot of the release in the output headers")
+	quiet      = flag.Bool("quiet", false, "print nothing when all the releases pass")
+	color      = flag.String("color", "auto", "color the output (auto, always or never)")
+	goname     = flag.String("gocmd", "go", "name of the go command in the bin directory of each release")
+	firstfail  = flag.Bool("first-fail", false, "stop after the first release that fails, the oldest unless -desc is set")
+	sdk        = flag.String("sdk", "", "path to the go sdk directory, overriding GOSDK and ~/sdk")
+	cmpsdk     = flag.String("compare-sdk", "", "compare the results with the same releases in the specified sdk directory, reporting the regressions and fixes")
+	layout     = flag.String("sdk-layout", "go*", "glob pattern for the names of the goroot directories in the sdk directory, like \"go-*\"")
+	noenv      = flag.Bool("no-env", false, "invoke the go tool with a clean environment, inheriting only PATH, HOME and the temporary directory")
+	goproxy    = flag.String("goproxy", "", "set GOPROXY for the go tool, like \"off\" for offline runs (empty means inherited)")
+	gosumdb    = flag.String("gosumdb", "", "set GOSUMDB for the go tool, like \"off\" to disable the checksum database (empty means inherited)")
+	vercache   = flag.Bool("cache-versions", true, "cache the versions reported by go version in the user cache directory")
+	keepgoing  = flag.Bool("keep-going", false, "skip the releases with fatal errors, instead of stopping")
+	pkgpar     = flag.Int("pkg-parallel", 0, "invoke the go tool concurrently on N shards of the packages within each release (0 or 1 means no sharding)")
+	retries    = flag.Int("retries", 0, "invoke the tool again up to N times when a release fails")
+	bisectflag = flag.Bool("bisect", false, "binary search the releases where the result changes between the oldest and the newest")
+	workspace  = flag.Bool("workspace", false, "run the go tool in the workspace root, if a go.work file is found")
+	isocache   = flag.Bool("isolate-cache", false, "use a separate, temporary, build cache for each release")
+	faildup    = flag.Bool("fail-duplicates", false, "report duplicate releases in the sdk directory as an error, instead of a warning")
+	fromci     = flag.String("min-go-from-ci", "", "use only the releases in the go-version entries of a GitHub Actions workflow file")
+	cfgfile    = flag.String("config", "", "read the default flags from the specified file, instead of "+defconfig)
+	progflag   = flag.Bool("progress", false, "report the progress of the run on stderr")
+	cmdflag    = flag.String("cmd", "", "custom go subcommand and arguments to run, like \"list -deps\", overriding -mode")
+	abspat     = flag.Bool("abs", false, "resolve the relative package patterns to absolute paths")
+	versenv    = flag.Bool("version-env", true, "set the environment variables required by old releases, like GO111MODULE=on for go1.11")
+	notests    = flag.Bool("no-tests", false, "report the packages without test files, in test mode")
+	vetlist    = flag.String("vet", "", "comma-separated list of vet analyzers, like \"printf,shadow=false\", in vet mode")
+	outfile    = flag.String("output", "", "write the results to the specified file, instead of stderr")
+	junitfile  = flag.String("junit", "", "write the results to the specified file as a JUnit XML report, with a test case for each release")
+	patfile    = flag.String("patterns-file", "", "read additional package patterns from the specified file, one per line")
+	since      version.Version
+	constraint version.Constraint
+	selectglob version.Glob
+	envflag    envlist
+	exclude    excludelist
+	require    versionlist
+	failon     failset
+	loglevel   = logging.Warn
 )
 
-type release struct {
-	goroot  string
-	version version.Version
+// output configures how the results are printed and reported.
+type output struct {
+	summary bool    // print a summary at the end of the run
+	quiet   bool    // print nothing when all the releases pass
+	color   bool    // color the output
+	verbose bool    // include the goroot in the headers
+	failon  failset // the results causing a non zero exit status
+	junit   string  // write a JUnit XML report to this file, if not empty
 }
 
-func (r release) String() string {
-	return "go" + r.version.String()
+// envlist is a list of environment variables, as KEY=VALUE, that can be
+// specified multiple times on the command line.
+type envlist []string
+
+// String implements the Stringer interface.
+func (l envlist) String() string {
+	return strings.Join(l, " ")
+}
+
+// Set implements the Value interface.
+func (l *envlist) Set(s string) error {
+	if strings.Index(s, "=") <= 0 {
+		return fmt.Errorf("environment variable must be KEY=VALUE")
+	}
+	*l = append(*l, s)
+
+	return nil
+}
+
+// versionlist is a list of go versions that can be specified multiple times
+// on the command line.
+type versionlist []version.Version
+
+// String implements the Stringer interface.
+func (l versionlist) String() string {
+	list := make([]string, 0, len(l))
+	for _, v := range l {
+		list = append(list, v.String())
+	}
+
+	return strings.Join(list, " ")
+}
+
+// Set implements the Value interface.
+func (l *versionlist) Set(s string) error {
+	var v version.Version
+	if err := v.Set(s); err != nil {
+		return err
+	}
+	*l = append(*l, v)
+
+	return nil
+}
+
+// excludelist is the list of releases excluded by the -exclude flag.  The
+// development versions are excluded with "tip" or "devel".
+type excludelist struct {
+	versions versionlist
+	devel    bool
+}
+
+// String implements the Stringer interface.
+func (l excludelist) String() string {
+	s := l.versions.String()
+	if l.devel {
+		s = strings.TrimSpace(s + " tip")
+	}
+
+	return s
+}
+
+// Set implements the Value interface.
+func (l *excludelist) Set(s string) error {
+	if s == "tip" || s == "devel" {
+		l.devel = true
+
+		return nil
+	}
+	if err := l.versions.Set(s); err != nil {
+		return fmt.Errorf("must be a go version, like 1.18 or go1.18.3, or tip")
+	}
+
+	return nil
+}
+
+// failcategories contains the categories of results accepted by the -fail-on
+// flag.  The "any" category includes all the failed releases, but not the
+// skipped ones.
+var failcategories = []string{"any", "vet", "build", "test", "compile",
+	"command", "timeout", "skip"}
+
+// failset is a set of categories of results, specified as a comma-separated
+// list on the command line.  An empty set means "any".
+type failset map[string]bool
+
+// String implements the Stringer interface.
+func (s failset) String() string {
+	list := make([]string, 0, len(s))
+	for _, c := range failcategories {
+		if s[c] {
+			list = append(list, c)
+		}
+	}
+
+	return strings.Join(list, ",")
+}
+
+// Set implements the Value interface.
+func (s *failset) Set(value string) error {
+	set := make(failset)
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		if !validcategory(c) {
+			return fmt.Errorf("unknown category %q, must be one of %s", c,
+				strings.Join(failcategories, ", "))
+		}
+		set[c] = true
+	}
+	*s = set
+
+	return nil
+}
+
+// validcategory returns true if c is in failcategories.
+func validcategory(c string) bool {
+	for _, name := range failcategories {
+		if c == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// category returns the category of res: the tool that reported the
+// diagnostics, "compile" for a package that does not compile, "command" for a
+// failed custom command, "timeout" or "skip".  It returns an empty string for
+// a release that passed.
+func category(res compatible.Result) string {
+	switch {
+	case res.Status == compatible.Skip:
+		return "skip"
+	case res.Status != compatible.Fail:
+		return ""
+	case res.Reason == "timeout":
+		return "timeout"
+	case res.Reason == "command failed":
+		return "command"
+	case res.Reason == "does not compile":
+		return "compile"
+	}
+
+	return res.Tool
+}
+
+// failed returns true if the category of at least one result is in s.
+func (s failset) failed(results []compatible.Result) bool {
+	for _, res := range results {
+		c := category(res)
+		switch {
+		case c == "":
+		case len(s) == 0 || s["any"]:
+			if c != "skip" || s["skip"] {
+				return true
+			}
+		case s[c]:
+			return true
+		}
+	}
+
+	return false
 }
 
 func init() {
 	flag.Var(&since, "since", "use only releases more recent than a specific version")
+	flag.Var(&constraint, "constraint", "use only releases matching a constraint, like \">=1.18 <1.22\"")
+	flag.Var(&selectglob, "select", "use only releases matching a glob pattern, like \"1.20.*\" or \"1.2?\"")
+	flag.Var(&exclude, "exclude", "exclude the release with the specified version, or tip for the development versions (can be repeated)")
+	flag.Var(&require, "require", "fail if the release with the specified version is not selected (can be repeated)")
+	flag.Var(&loglevel, "log-level", "log the messages up to the specified level (error, warn, info or debug)")
+	flag.Var(&failon, "fail-on", "comma-separated list of results causing a non zero exit status: "+
+		strings.Join(failcategories, ", ")+" (default any)")
+	flag.Var(&envflag, "env", "set an environment variable, as KEY=VALUE, for the go tool (can be repeated)")
 }
 
-func init() {
-	if value, ok := os.LookupEnv("GOSDK"); ok {
-		gosdk = value
+// versioncache returns the path of the file caching the versions reported by
+// go version, in the user cache directory.  It returns an empty string, that
+// disables the cache, if the user cache directory is not available.
+func versioncache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
 
-		return
+	return filepath.Join(dir, "go-compatible", "versions.json")
+}
+
+// sdkdir returns the path to the go sdk directory.  The directory specified
+// with the -sdk flag takes precedence over the GOSDK environment variable,
+// that takes precedence over ~/sdk.
+func sdkdir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	if value, ok := os.LookupEnv("GOSDK"); ok {
+		return value, nil
 	}
 
 	home, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get home directory: %v\n", err)
-
-		return
+		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
-	gosdk = filepath.Join(home, "sdk")
+
+	return filepath.Join(home, "sdk"), nil
 }
 
 func main() {
@@ -69,239 +324,889 @@ func main() {
 	// Parse command line.
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
-		fmt.Fprintln(w, "Usage: go-compatible [-mode mode] [-since goversion] [packages]")
+		fmt.Fprintln(w, "Usage: go-compatible [options] packages [-- toolargs]")
+		fmt.Fprintln(w, "       go-compatible -list [options]")
 		fmt.Fprintln(w, "Options:")
 		flag.PrintDefaults()
 	}
-	flag.Parse()
-	args := flag.Args()
+	// The arguments after "--" are forwarded to the go tool.  Note that they
+	// must be separated before parsing, since the flag package discards the
+	// "--" terminator.
+	cmdargs, toolargs := splitargs(os.Args[1:])
+	flag.CommandLine.Parse(cmdargs) // exits on errors
+	cfgpatterns, err := loadconfig(flag.CommandLine, *cfgfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.SetLevel(loglevel)
+	patargs := flag.Args()
+	if len(patargs) == 0 {
+		patargs = cfgpatterns
+	}
+	args, err := cmdpatterns(patargs, *patfile, os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkpatterns(args, toolargs, notool()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+
+		os.Exit(2)
+	}
+	args, err = resolvepatterns(args, *chdir, *abspat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	gosdk, err = sdkdir(*sdk)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.Debugf("using the sdk directory %s", gosdk)
 	switch *mode {
-	case "vet", "build", "test":
+	case "vet", "build", "test", "auto":
 	default:
-		const err = "must be \"vet\", \"build\" or \"test\""
+		const err = "must be \"vet\", \"build\", \"test\" or \"auto\""
 		fmt.Fprintf(os.Stderr, "invalid value %q for flag -mode: %s\n", *mode, err)
 		flag.Usage()
 
 		os.Exit(2)
 	}
+	colored, err := usecolor(*color, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for flag -color: %v\n", *color, err)
+		flag.Usage()
+
+		os.Exit(2)
+	}
+	if *dllist != "" {
+		names, err := dlnames(*dllist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for flag -download: %v\n", *dllist, err)
+			flag.Usage()
+
+			os.Exit(2)
+		}
+		download(names)
+	}
+
+	analyzers, err := vetanalyzers(*vetlist)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for flag -vet: %v\n", *vetlist, err)
+		flag.Usage()
 
-	releases, err := gosdklist(since)
+		os.Exit(2)
+	}
+
+	var pattern *regexp.Regexp
+	if *match != "" {
+		pattern, err = regexp.Compile(*match)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for flag -match: %v\n", *match, err)
+			flag.Usage()
+
+			os.Exit(2)
+		}
+	}
+
+	if err := checkshuffle(*shuffle); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value %q for flag -shuffle: %v\n", *shuffle, err)
+		flag.Usage()
+
+		os.Exit(2)
+	}
+
+	var ignorere *regexp.Regexp
+	if *ignore != "" {
+		ignorere, err = regexp.Compile(*ignore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for flag -ignore: %v\n", *ignore, err)
+			flag.Usage()
+
+			os.Exit(2)
+		}
+	}
+
+	var only versionlist
+	if *verlist != "" {
+		only, err = parseversions(*verlist)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for flag -versions: %v\n", *verlist, err)
+			flag.Usage()
+
+			os.Exit(2)
+		}
+	}
+
+	// The output of the go tool is text: use the same line endings on all
+	// the platforms.
+	invoke.NormalizeCRLF = true
+	compatible.GoCmd = *goname
+	if *vercache {
+		compatible.VersionCache = versioncache()
+	}
+	if *checksdk {
+		ok, err := printsdk(os.Stdout, gosdk, *layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+
+		return
+	}
+	filter := compatible.Filter{
+		Since:      since,
+		Latest:     *latest,
+		Minor:      *minor,
+		Stable:     *stable,
+		Constraint: constraint,
+		Pattern:    pattern,
+		Glob:       selectglob,
+		Exclude:    exclude.versions,
+
+		FailDuplicates:   *faildup,
+		ExcludeDevel:     exclude.devel,
+		SincePreReleases: *sincepre,
+		Layout:           *layout,
+		Descending:       *desc,
+	}
+	if only != nil {
+		// The explicit releases bypass the other filters.
+		filter = compatible.Filter{
+			FailDuplicates: *faildup,
+			Layout:         *layout,
+			Descending:     *desc,
+		}
+	}
+	releases, err := compatible.DiscoverReleases(gosdk, filter)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if only != nil {
+		var missing []version.Version
+		releases, missing = selectversions(releases, only)
+		if len(missing) > 0 {
+			log.Fatalf("releases not found in %s: %s", gosdk,
+				versionlist(missing))
+		}
+	}
+	if missing := missingreleases(releases, require); len(missing) > 0 {
+		log.Fatalf("required releases not found in %s: %s", gosdk,
+			versionlist(missing))
+	}
+	if *fromci != "" {
+		entries, err := readworkflow(*fromci)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var missing []string
+		releases, missing = selectci(releases, entries)
+		for _, name := range missing {
+			logging.Warnf("go-version %s in %s is not installed", name, *fromci)
+		}
+		if len(releases) == 0 {
+			log.Fatalf("no installed releases match the go-version entries in %s",
+				*fromci)
+		}
+	}
+	logging.Infof("using %d releases from %s", len(releases), gosdk)
+	if *list {
+		printlist(os.Stdout, releases)
+
+		return
+	}
+
+	// Kill the current subprocess on SIGINT or SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
 
-	if err := run(releases, args, *mode); err != nil {
+	opts := compatible.Options{
+		Mode:      *mode,
+		Timeout:   *timeout,
+		Platforms: compatible.Platforms(*goos, *goarch),
+		Race:      *race,
+		Args:      toolargs,
+		DryRun:    *dryrun,
+		Stdout:    os.Stdout,
+		Dir:       *chdir,
+		Tags:      *tags,
+		Env:       envflag,
+		CleanEnv:  *noenv,
+		Proxy:     *goproxy,
+		SumDB:     *gosumdb,
+		FirstFail: *firstfail,
+		Retries:   *retries,
+		KeepGoing: *keepgoing,
+
+		PkgParallel: *pkgpar,
+
+		VersionEnv: *versenv,
+		Analyzers:  analyzers,
+		NoTests:    *notests,
+		Shuffle:    *shuffle,
+		PkgTimeout: *pkgtimeout,
+		Ignore:     ignorere,
+	}
+	out := output{
+		summary: *summary,
+		quiet:   *quiet,
+		color:   colored,
+		failon:  failon,
+		verbose: *verbose,
+		junit:   *junitfile,
+	}
+	if *workspace {
+		root, err := compatible.FindWorkspace(opts.Dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if root != "" {
+			opts.Dir = root
+			opts.Workspace = true
+		}
+	}
+	if *cmdflag != "" {
+		opts.Command = strings.Fields(*cmdflag)
+	}
+	if *progflag {
+		opts.Report = progress(os.Stderr, opts)
+	}
+	if *isocache {
+		dir, err := os.MkdirTemp("", "go-compatible-cache-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.CacheDir = dir
+	}
+	if *printenvf {
+		err := printenv(os.Stdout, releases, opts)
+		if opts.CacheDir != "" {
+			os.RemoveAll(opts.CacheDir)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+	var w io.Writer = os.Stderr
+	var f *os.File
+	if *outfile != "" {
+		f, err = os.Create(*outfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out.color, _ = usecolor(*color, f) // the flag has been validated
+		w = f
+	}
+	var failed bool
+	if *cmpsdk != "" {
+		var newreleases []compatible.Release
+		newreleases, err = compatible.DiscoverReleases(*cmpsdk, filter)
+		if err == nil {
+			failed, err = comparesdk(ctx, w, releases, newreleases, args, opts)
+		}
+	} else {
+		failed, err = check(ctx, w, releases, args, opts, out)
+	}
+	if opts.CacheDir != "" {
+		os.RemoveAll(opts.CacheDir)
+	}
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
+	if failed {
+		os.Exit(1)
+	}
 }
 
-// run invokes go vet or go test for all the specified releases.
-func run(releases []release, patterns []string, mode string) error {
-	tool := govet
-	switch mode {
-	case "build":
-		tool = gobuild
-	case "test":
-		tool = gotest
+// check invokes the go tool on the releases, or bisects the releases when the
+// -bisect flag is set, and prints the results to w and, if out.junit is set,
+// to a JUnit XML report.  It returns true if at least one result is in
+// out.failon, except when bisecting, where failures are expected.
+func check(ctx context.Context, w io.Writer, releases []compatible.Release, patterns []string, opts compatible.Options, out output) (bool, error) {
+	if *bisectflag {
+		results, index, err := compatible.Bisect(ctx, releases, patterns, opts)
+		printresults(w, results, opts, out)
+		if err := savejunit(out, results, err); err != nil {
+			return false, err
+		}
+		printbisect(w, results, index, opts)
+
+		return false, nil
 	}
+	results, err := compatible.Run(ctx, releases, patterns, opts)
+	printresults(w, results, opts, out)
+	err = savejunit(out, results, err)
 
-	nl := []byte("\n")
-	index := 0 // current failed release
+	return out.failon.failed(results), err
+}
 
-	for _, rel := range releases {
-		msg, err := tool(rel, patterns)
-		if err != nil {
-			return err
+// savejunit writes the results to the out.junit file, if set, also when the
+// run failed with err, so that the results are not lost.  It returns err, if
+// not nil, or the error writing the file.
+func savejunit(out output, results []compatible.Result, err error) error {
+	if out.junit == "" {
+		return err
+	}
+	if jerr := writejunit(out.junit, results); err == nil {
+		err = jerr
+	}
+
+	return err
+}
+
+// comparesdk invokes the go tool on the releases from two sdk directories and
+// prints to w the releases, matched by version, that changed status from the
+// old to the new directory.  The releases found in only one of the
+// directories are skipped with a warning.  It returns true if at least one
+// release regressed.
+func comparesdk(ctx context.Context, w io.Writer, old, new []compatible.Release, patterns []string, opts compatible.Options) (bool, error) {
+	old, new = matchreleases(old, new)
+	if len(new) == 0 {
+		return false, errors.New("no releases in common between the sdk directories")
+	}
+
+	oldres, err := compatible.Run(ctx, old, patterns, opts)
+	if err != nil {
+		return false, err
+	}
+	newres, err := compatible.Run(ctx, new, patterns, opts)
+	if err != nil {
+		return false, err
+	}
+	changes := compatible.Diff(oldres, newres)
+	printdiff(w, changes, opts)
+
+	for _, c := range changes {
+		if c.Regression() {
+			return true, nil
 		}
-		if msg == nil {
+	}
+
+	return false, nil
+}
+
+// matchreleases returns the releases in old and new with a version found in
+// both lists, logging a warning for the other releases.  Development versions
+// can not be matched.
+func matchreleases(old, new []compatible.Release) ([]compatible.Release, []compatible.Release) {
+	filter := func(list, other []compatible.Release) []compatible.Release {
+		var matched []compatible.Release
+		for _, rel := range list {
+			found := false
+			for _, o := range other {
+				if !rel.Devel && !o.Devel && rel.Version.Equal(o.Version) {
+					found = true
+
+					break
+				}
+			}
+			if !found {
+				logging.Warnf("skipping %s (%s): not found in both sdk directories",
+					rel, rel.GoRoot)
+
+				continue
+			}
+			matched = append(matched, rel)
+		}
+
+		return matched
+	}
+
+	return filter(old, new), filter(new, old)
+}
+
+// printdiff prints to w a line for each change, with the release and
+// platform and the old and new status, followed by a line with the number of
+// regressions and fixes.
+func printdiff(w io.Writer, changes []compatible.Change, opts compatible.Options) {
+	regressions, fixes := 0, 0
+	for _, c := range changes {
+		kind := "changed"
+		switch {
+		case c.Regression():
+			kind = "regression"
+			regressions++
+		case c.Fix():
+			kind = "fix"
+			fixes++
+		}
+		fmt.Fprintf(w, "%s: %s: %s -> %s\n", kind,
+			compatible.Target(c.New.Release, c.New.Platform, opts),
+			c.Old.Status, c.New.Status)
+	}
+	fmt.Fprintf(w, "%d regressions, %d fixes\n", regressions, fixes)
+}
+
+// progress returns a function for compatible.Options.Report that prints to w
+// the release and platform before the tool is invoked.
+func progress(w io.Writer, opts compatible.Options) compatible.ReportFunc {
+	return func(ev compatible.Event) {
+		if ev.Kind != compatible.EventStarted {
+			return
+		}
+		fmt.Fprintf(w, "[%d/%d] running %s...\n", ev.N, ev.Total,
+			compatible.Target(ev.Release, ev.Platform, opts))
+	}
+}
+
+// printresults prints the diagnostic message of each result to w, preceded by
+// a header with the release and platform.  The header of a release is only
+// printed when the tool reports a diagnostic message.
+//
+// If out.summary is set, a summary of the results is printed at the end.  The
+// last line reports the number of releases, failed releases and skipped
+// releases.  Nothing is printed after the diagnostic messages in dry run
+// mode, since no tool is invoked, and when out.quiet is set and all the
+// releases passed.
+func printresults(w io.Writer, results []compatible.Result, opts compatible.Options, out output) {
+	nl := []byte("\n")
+	index := 0 // current failed release
+	for _, res := range results {
+		if res.Msg == nil {
 			continue
 		}
 
 		// Print go vet diagnostic message or go test report
 		if index > 0 {
-			os.Stderr.Write(nl)
+			w.Write(nl)
 		}
-		fmt.Fprintf(os.Stderr, "using go%s\n", rel.version)
-		os.Stderr.Write(msg)
-		os.Stderr.Write(nl)
+		header := "using " + compatible.Target(res.Release, res.Platform, opts)
+		if out.verbose {
+			header += " from " + res.Release.GoRoot
+		}
+		header += " (" + fmtduration(res.Duration) + ")"
+		if p := panicline(res.Msg); p != "" {
+			header += ": " + p
+		}
+		fmt.Fprintln(w, paint(header, statuscolor(res.Status), out.color))
+		w.Write(res.Msg)
+		w.Write(nl)
 
 		index++
 	}
 
-	if mode == "build" {
-		return goclean()
+	if opts.DryRun || (out.quiet && passed(results)) {
+		return
 	}
+	if out.summary {
+		if index > 0 {
+			w.Write(nl)
+		}
+		printsummary(w, results, opts, out)
+	} else if index > 0 {
+		w.Write(nl)
+	}
+	fmt.Fprintln(w, fmtcounts(compatible.Count(results)))
+}
 
-	return nil
+// fmtcounts formats the counts as a single line with a stable format, like
+// "12 releases, 3 failed, 1 skipped", that can be parsed by other tools.
+func fmtcounts(c compatible.Counts) string {
+	return fmt.Sprintf("%d releases, %d failed, %d skipped", c.Total, c.Failed,
+		c.Skipped)
 }
 
-// gosdklist returns a list of all go releases in the sdk more recent than the
-// specified version.
-func gosdklist(since version.Version) ([]release, error) {
-	list := make([]release, 0, 32) // preallocate memory
-	files, err := os.ReadDir(gosdk)
-	if err != nil {
-		return nil, err
+// passed returns true if all the results have the pass status.
+func passed(results []compatible.Result) bool {
+	for _, res := range results {
+		if res.Status != compatible.Pass {
+			return false
+		}
 	}
-	for _, file := range files {
-		name := file.Name()
-		if file.IsDir() && strings.HasPrefix(name, "go") {
-			goroot := filepath.Join(gosdk, name)
-			line, err := goversion(goroot)
-			if err != nil {
-				return nil, err
-			}
-			version, err := version.ParseLine(line)
-			if err != nil {
-				return nil, err
-			}
 
-			if version.Less(since) {
-				continue
+	return true
+}
+
+// reason returns the reason of res to report in the summary: the panic
+// reported in the diagnostic message of a failed release, if any, or
+// res.Reason.
+func reason(res compatible.Result) string {
+	if res.Status == compatible.Fail {
+		if p := panicline(res.Msg); p != "" {
+			return p
+		}
+	}
+
+	return res.Reason
+}
+
+// panicline returns the first line of a Go panic reported in msg, like
+// "panic: runtime error: index out of range", or an empty string.  A panic
+// is detected by a line starting with "panic: ", followed by the trace of a
+// goroutine, so that a test printing "panic: " is not reported.
+func panicline(msg []byte) string {
+	lines := strings.Split(string(msg), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "panic: ") {
+			continue
+		}
+		for _, next := range lines[i+1:] {
+			if strings.HasPrefix(next, "goroutine ") {
+				return line
 			}
+		}
+
+		return ""
+	}
+
+	return ""
+}
+
+// printsummary prints a table with the status and duration of each result to
+// w, followed by the total duration.
+func printsummary(w io.Writer, results []compatible.Result, opts compatible.Options, out output) {
+	var total time.Duration
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, res := range results {
+		status := paint(res.Status.String(), statuscolor(res.Status), out.color)
+		fmt.Fprintf(tw, "%s\t%s\t%s", compatible.Target(res.Release, res.Platform, opts),
+			status, fmtduration(res.Duration))
+		if reason := reason(res); reason != "" {
+			fmt.Fprintf(tw, "\t%s", reason)
+		}
+		fmt.Fprintln(tw)
+		total += res.Duration
+	}
+	// The empty status is painted, so that tabwriter computes the same width
+	// as for the other colored statuses.
+	fmt.Fprintf(tw, "total\t%s\t%s\n", paint("", green, out.color),
+		fmtduration(total))
+	tw.Flush()
+}
+
+// fmtduration formats a duration for the output, rounding it to 0.1s, or to
+// 1ms for durations less than 1s.
+func fmtduration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// printbisect prints to w the two adjacent releases where the result changes,
+// given the results and the index returned by compatible.Bisect.
+func printbisect(w io.Writer, results []compatible.Result, index int, opts compatible.Options) {
+	before := results[index-1]
+	after := results[index]
+	fmt.Fprintf(w, "result changes from %s in %s to %s in %s\n",
+		before.Status, compatible.Target(before.Release, before.Platform, opts),
+		after.Status, compatible.Target(after.Release, after.Platform, opts))
+}
+
+// printlist prints the version and goroot of each release to w.
+func printlist(w io.Writer, releases []compatible.Release) {
+	for _, rel := range releases {
+		fmt.Fprintf(w, "%s\t%s\n", rel, rel.GoRoot)
+	}
+}
+
+// checkshuffle checks the value of the -shuffle flag, accepted by go test:
+// "on", "off" or an integer seed.  An empty value means not set.
+func checkshuffle(value string) error {
+	switch value {
+	case "", "on", "off":
+		return nil
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		return errors.New("must be \"on\", \"off\" or an integer seed")
+	}
+
+	return nil
+}
 
-			rel := release{
-				goroot:  goroot,
-				version: version,
+// parseversions parses a comma-separated list of go versions.  The "go"
+// prefix is optional.
+func parseversions(list string) (versionlist, error) {
+	var l versionlist
+	for _, s := range strings.Split(list, ",") {
+		if err := l.Set(strings.TrimSpace(s)); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// selectversions returns the releases, in order, with a version in the list
+// and the versions that do not match any release.
+func selectversions(releases []compatible.Release, list []version.Version) ([]compatible.Release, []version.Version) {
+	found := make([]bool, len(list))
+	var selected []compatible.Release
+	for _, rel := range releases {
+		match := false
+		for i, v := range list {
+			if !rel.Devel && rel.Version.Equal(v) {
+				found[i] = true
+				match = true
 			}
-			list = append(list, rel)
+		}
+		if match {
+			selected = append(selected, rel)
 		}
 	}
-	if len(list) == 0 {
-		return nil, fmt.Errorf("no go releases found in %s", gosdk)
+
+	var missing []version.Version
+	for i, v := range list {
+		if !found[i] {
+			missing = append(missing, v)
+		}
 	}
 
-	// Sort the releases.
-	sort.Slice(list, func(i, j int) bool {
-		return list[i].version.Less(list[j].version)
-	})
+	return selected, missing
+}
 
-	return list, nil
+// missingreleases returns the versions in required that are not present in
+// the releases.  As with -versions, a required version must match a release
+// exactly, so that go1.21.3 is not satisfied by go1.21.5 and go1.18 is not
+// satisfied by go1.18rc1.  Development versions never satisfy a required
+// version.
+func missingreleases(releases []compatible.Release, required []version.Version) []version.Version {
+	var missing []version.Version
+	for _, v := range required {
+		found := false
+		for _, rel := range releases {
+			if !rel.Devel && rel.Version.Equal(v) {
+				found = true
+
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, v)
+		}
+	}
+
+	return missing
 }
 
-// goclean invokes go clean to clean the files generated by go build in the
-// current directory, for versions older than go1.8.
-func goclean() error {
-	// Use the go command installed in the system.
-	cmd := exec.Command("go", "clean")
+// printenv prints to w, for each release and platform, a line with the
+// release and platform followed by the environment variables set for the go
+// tool, one per line and indented with a tab.
+func printenv(w io.Writer, releases []compatible.Release, opts compatible.Options) error {
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = []compatible.Platform{{}} // host platform
+	}
+	for _, rel := range releases {
+		for _, plat := range platforms {
+			env, err := compatible.Env(rel, plat, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, compatible.Target(rel, plat, opts))
+			for _, kv := range env {
+				fmt.Fprintf(w, "\t%s\n", kv)
+			}
+		}
+	}
+
+	return nil
+}
+
+// printsdk checks the goroots in the sdk directory dir with a name matching
+// layout and prints to w the releases, followed by the problems found and a
+// line with the number of releases and problems.  It returns true if no
+// problems were found.
+func printsdk(w io.Writer, dir, layout string) (bool, error) {
+	releases, problems, err := compatible.CheckSDK(dir, layout)
+	if err != nil {
+		return false, err
+	}
+	printlist(w, releases)
+	for _, p := range problems {
+		fmt.Fprintf(w, "error: %v\n", p)
+	}
+	fmt.Fprintf(w, "%d releases, %d problems\n", len(releases), len(problems))
 
-	return invoke.Run(cmd)
+	return len(problems) == 0, nil
 }
 
-// goversion returns the version of go from goroot.
-func goversion(goroot string) (string, error) {
-	gocmd := filepath.Join(goroot, "bin", "go")
-	cmd := exec.Command(gocmd, "version")
-	cmd.Env = append(os.Environ(), "GOROOT="+goroot)
+// cmdpatterns returns the package patterns specified on the command line in args,
+// followed by the patterns read from file, if not empty.  When args contains
+// only "-", the patterns on the command line are read from stdin.
+func cmdpatterns(args []string, file string, stdin io.Reader) ([]string, error) {
+	if len(args) == 1 && args[0] == "-" {
+		list, err := readpatterns(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("stdin: %w", err)
+		}
+		args = list
+	}
+	if file == "" {
+		return args, nil
+	}
 
-	stdout, err := invoke.Output(cmd)
+	f, err := os.Open(file)
 	if err != nil {
-		// TODO(mperillo): Ignore the case of gocmd not found.
-		return "", err
+		return nil, err
 	}
+	defer f.Close()
 
-	return string(stdout), nil
+	list, err := readpatterns(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return append(args, list...), nil
 }
 
-// govet invokes go vet on the packages named by the given patterns, for the
-// specified release.  It returns the diagnostic message and a non nil error,
-// in case of a fatal error like go command not found.
-func govet(rel release, patterns []string) ([]byte, error) {
-	// TODO(mperillo): go1.4 does not have the go vet tool;  report an useful
-	// error if the user has not installed it.
-	gocmd := filepath.Join(rel.goroot, "bin", "go")
-	args := append([]string{"vet"}, patterns...)
-	cmd := exec.Command(gocmd, args...)
-	cmd.Env = append(os.Environ(), "GOROOT="+rel.goroot)
+// errNoPatterns is the error returned by checkpatterns when no package
+// patterns are specified.
+var errNoPatterns = errors.New("no package patterns specified, use . or ./... " +
+	"for the packages in the current directory")
 
-	if err := invoke.Run(cmd); err != nil {
-		cmderr := err.(*invoke.Error)
+// errPatternsAfterSep is the error returned by checkpatterns when no package
+// patterns are specified, but there are arguments after the "--" separator,
+// that are forwarded to the go tool and can not contain the patterns.
+var errPatternsAfterSep = errors.New("no package patterns specified, the " +
+	"package patterns must precede the -- separator, like ./... -- -tags x")
 
-		// Determine the error type to decide if there was a fatal problem
-		// with the invocation of go vet that requires the termination of
-		// the program.
-		switch cmderr.Err.(type) {
-		case *exec.Error:
-			return nil, err
-		case *exec.ExitError:
-			return cmderr.Stderr, nil
+// notool returns true if the command line options only print information
+// about the releases and exit, without invoking the go tool: -list,
+// -check-sdk and -print-env.
+func notool() bool {
+	return *list || *checksdk || *printenvf
+}
+
+// checkpatterns returns errNoPatterns if patterns is empty, unless only the
+// releases are listed.  The go tool would use the package in the current
+// directory, that is not always what the user intended.  When toolargs is
+// not empty, errPatternsAfterSep is returned instead, since the patterns were
+// probably specified after the "--" separator.
+func checkpatterns(patterns, toolargs []string, list bool) error {
+	if len(patterns) == 0 && !list {
+		if len(toolargs) > 0 {
+			return errPatternsAfterSep
 		}
 
-		return nil, err // should not be reached
+		return errNoPatterns
 	}
 
-	return nil, nil
+	return nil
 }
 
-var go18 = version.Must(version.Parse("go1.8"))
+// resolvepatterns validates the file system patterns, like ./... or
+// /src/pkg, checking that the directory they refer to exists.  Relative
+// patterns are relative to dir, or to the current directory if dir is empty,
+// as done by the go tool.
+//
+// If abs is set, the relative patterns are resolved to absolute paths, so
+// that all the releases see the same packages, regardless of the working
+// directory of the go tool.  Import path patterns, like std or
+// example.com/..., are returned unchanged.
+func resolvepatterns(patterns []string, dir string, abs bool) ([]string, error) {
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
 
-// gobuild invokes go build on the packages named by the given patterns, for
-// the specified release.  It returns the diagnostic message and a non nil
-// error, in case of a fatal error like go command not found.
-func gobuild(rel release, patterns []string) ([]byte, error) {
-	gocmd := filepath.Join(rel.goroot, "bin", "go")
-	var args = []string{"build"}
+	list := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if !isfspattern(pattern) {
+			list = append(list, pattern)
 
-	if rel.version.Less(go18) {
-		// Invoke `go build [packages]`.
-		// It is not the default choice because, in case patterns match a
-		// single main package, go build will write the generated binary in the
-		// current directory.
-		args = append(args, patterns...)
-	} else {
-		// Invoke `go build -o /dev/null [packages]`.
-		// Note that this is not documented.
-		args := append(args, "-o", os.DevNull)
-		args = append(args, patterns...)
-	}
-	cmd := exec.Command(gocmd, args...)
-	cmd.Env = append(os.Environ(), "GOROOT="+rel.goroot)
-
-	if err := invoke.Run(cmd); err != nil {
-		cmderr := err.(*invoke.Error)
-
-		// Determine the error type to decide if there was a fatal problem
-		// with the invocation of go build that requires the termination of
-		// the program.
-		switch cmderr.Err.(type) {
-		case *exec.Error:
-			return nil, err
-		case *exec.ExitError:
-			return cmderr.Stderr, nil
+			continue
 		}
 
-		return nil, err // should not be reached
+		// Strip the ... wildcard suffix, like in ./... or ./cmd/....
+		path, suffix := pattern, ""
+		if strings.HasSuffix(path, "...") {
+			path = strings.TrimSuffix(path, "...")
+			suffix = "..."
+			if trimmed := strings.TrimRight(path, `/\`); trimmed != path {
+				suffix = path[len(trimmed):] + suffix
+				path = trimmed
+			}
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+		// A wildcard in the last element, like ./cmd..., may not match the
+		// directory itself.
+		if !strings.Contains(path, "...") && suffix != "..." {
+			if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+				return nil, fmt.Errorf("pattern %s: directory %s does not exist",
+					pattern, path)
+			}
+		}
+		if abs {
+			pattern = path + suffix
+		}
+		list = append(list, pattern)
 	}
 
-	return nil, nil
+	return list, nil
 }
 
-// gotest invokes go test on the packages named by the given patterns, for the
-// specified release.  It returns the test report and a non nil error, in case
-// of a fatal error like go command not found.
-//
-// For older versions go test report more errors compared to go vet.
-func gotest(rel release, patterns []string) ([]byte, error) {
-	gocmd := filepath.Join(rel.goroot, "bin", "go")
-	args := append([]string{"test"}, patterns...)
-	cmd := exec.Command(gocmd, args...)
-	cmd.Env = append(os.Environ(), "GOROOT="+rel.goroot)
-
-	// go test writes the go vet diagnostic on stderr and the test report on
-	// stdout.
-	if data, err := cmd.CombinedOutput(); err != nil {
-		// Determine the error type to decide if there was a fatal problem
-		// with the invocation of go test that requires the termination of
-		// the program.
-		switch err.(type) {
-		case *exec.Error:
-			return nil, err
-		case *exec.ExitError:
-			return bytes.TrimSpace(data), nil
+// isfspattern returns true if pattern is a file system pattern, that is an
+// absolute path or a path starting with . or .., instead of an import path.
+func isfspattern(pattern string) bool {
+	switch {
+	case pattern == "." || pattern == "..":
+		return true
+	case strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../"):
+		return true
+	case filepath.Separator == '\\' &&
+		(strings.HasPrefix(pattern, `.\`) || strings.HasPrefix(pattern, `..\`)):
+		return true
+	}
+
+	return filepath.IsAbs(pattern)
+}
+
+// vetanalyzers parses a comma-separated list of vet analyzers, with an
+// optional value, like "printf,shadow=false".  The leading dash is optional.
+func vetanalyzers(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var analyzers []string
+	for _, a := range strings.Split(list, ",") {
+		a = strings.TrimPrefix(strings.TrimSpace(a), "-")
+		if a == "" || strings.HasPrefix(a, "=") || strings.ContainsAny(a, " \t") {
+			return nil, fmt.Errorf("invalid analyzer %q", a)
+		}
+		analyzers = append(analyzers, a)
+	}
+
+	return analyzers, nil
+}
+
+// readpatterns reads the package patterns from r, one per line.  Empty lines
+// are ignored.
+func readpatterns(r io.Reader) ([]string, error) {
+	var list []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			list = append(list, line)
 		}
+	}
 
-		return nil, err // should not be reached
+	return list, sc.Err()
+}
+
+// splitargs splits the command line arguments at the first "--" separator.
+// The arguments after the separator are forwarded verbatim to the go tool.
+func splitargs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
 	}
 
-	return nil, nil
+	return args, nil
 }
@@ -0,0 +1,946 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perillo/go-compatible/compatible"
+	"github.com/perillo/go-compatible/internal/fakesdk"
+	"github.com/perillo/go-compatible/version"
+)
+
+// TestSummary tests that the summary reflects a mix of passing, failing and
+// skipped releases.
+func TestSummary(t *testing.T) {
+	results := []compatible.Result{
+		newresult("go1.0", compatible.Skip, "race detector not supported", ""),
+		newresult("go1.15", compatible.Fail, "diagnostics found", "FAIL"),
+		newresult("go1.16", compatible.Fail, "timeout", "timeout: killed after 100ms"),
+		newresult("go1.17", compatible.Pass, "", ""),
+	}
+	setduration(results, 1500*time.Millisecond)
+
+	buf := new(bytes.Buffer)
+	printsummary(buf, results, compatible.Options{}, output{})
+	want := "go1.0   SKIP  1.5s  race detector not supported\n" +
+		"go1.15  FAIL  1.5s  diagnostics found\n" +
+		"go1.16  FAIL  1.5s  timeout\n" +
+		"go1.17  PASS  1.5s\n" +
+		"total         6s\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want summary = %q, got %q", want, s)
+	}
+}
+
+// TestPanic tests that a panic in the test report of a release is reported
+// in its header and in the summary.
+func TestPanic(t *testing.T) {
+	const trace = "--- FAIL: TestIndex (0.00s)\n" +
+		"panic: runtime error: index out of range [3] with length 3 [recovered]\n" +
+		"\tpanic: runtime error: index out of range [3] with length 3\n" +
+		"\n" +
+		"goroutine 7 [running]:\n" +
+		"testing.tRunner.func1.2({0x5b2f40, 0xc000018150})\n" +
+		"\t/usr/local/go/src/testing/testing.go:1209 +0x24e\n" +
+		"FAIL\texample.com/pkg\t0.01s"
+	const line = "panic: runtime error: index out of range [3] with length 3 [recovered]"
+
+	var tests = []struct {
+		msg  string
+		want string
+	}{
+		{trace, line},
+		{"--- FAIL: TestPrint (0.00s)\n    print_test.go:8: panic: not a trace\nFAIL", ""},
+		{"panic: boom\n\ngoroutine 1 [running]:\nmain.main()", "panic: boom"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if s := panicline([]byte(test.msg)); s != test.want {
+			t.Errorf("%q: want panic = %q, got %q", test.msg, test.want, s)
+		}
+	}
+
+	results := []compatible.Result{
+		newresult("go1.18", compatible.Pass, "", ""),
+		newresult("go1.19", compatible.Fail, "diagnostics found", trace),
+		newresult("go1.20", compatible.Fail, "diagnostics found", "FAIL"),
+	}
+	setduration(results, time.Second)
+	buf := new(bytes.Buffer)
+	printresults(buf, results, compatible.Options{}, output{summary: true})
+	want := "using go1.19 (1s): " + line + "\n" + trace + "\n\n" +
+		"using go1.20 (1s)\nFAIL\n\n" +
+		"go1.18  PASS  1s\n" +
+		"go1.19  FAIL  1s  " + line + "\n" +
+		"go1.20  FAIL  1s  diagnostics found\n" +
+		"total         3s\n" +
+		"3 releases, 2 failed, 0 skipped\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+}
+
+// TestQuiet tests that nothing is printed for an all-passing run when
+// out.quiet is set, and that the summary is printed when a release fails.
+func TestQuiet(t *testing.T) {
+	opts := compatible.Options{Mode: "test"}
+	out := output{summary: true, quiet: true}
+
+	results := []compatible.Result{
+		newresult("go1.16", compatible.Pass, "", ""),
+		newresult("go1.17", compatible.Pass, "", ""),
+	}
+	buf := new(bytes.Buffer)
+	printresults(buf, results, opts, out)
+	if s := buf.String(); s != "" {
+		t.Errorf("want output = %q, got %q", "", s)
+	}
+
+	results = append(results,
+		newresult("go1.18", compatible.Fail, "diagnostics found", "FAIL"))
+	setduration(results, 250*time.Millisecond)
+	buf.Reset()
+	printresults(buf, results, opts, out)
+	want := "using go1.18 (250ms)\nFAIL\n\n" +
+		"go1.16  PASS  250ms\n" +
+		"go1.17  PASS  250ms\n" +
+		"go1.18  FAIL  250ms  diagnostics found\n" +
+		"total         750ms\n" +
+		"3 releases, 1 failed, 0 skipped\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+}
+
+// TestOutput tests that check writes the results to the specified file, as
+// done by the -output flag.
+func TestOutput(t *testing.T) {
+	// The go command reports a vet diagnostic for go1.16.
+	const script = `case "$1" in
+version) echo "go version $(basename "$GOROOT") linux/amd64" ;;
+*) case "$GOROOT" in *go1.16) echo "x.go:1:1: unreachable code" >&2; exit 1 ;; esac ;;
+esac`
+
+	sdk := t.TempDir()
+	for _, goversion := range []string{"go1.16", "go1.17"} {
+		fakesdk.Goroot(t, filepath.Join(sdk, goversion), script)
+	}
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "results.txt")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := compatible.Options{Mode: "vet"}
+	failed, err := check(context.Background(), f, releases, []string{"./..."},
+		opts, output{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if !failed {
+		t.Error("expected failed == true")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The durations are not known in advance.
+	duration := regexp.MustCompile(`\([^)]+\)`)
+	got := duration.ReplaceAllString(string(data), "(0s)")
+	want := "using go1.16 (0s)\nx.go:1:1: unreachable code\n\n" +
+		"2 releases, 1 failed, 0 skipped\n"
+	if got != want {
+		t.Errorf("want output = %q, got %q", want, got)
+	}
+}
+
+// TestCheck tests that check writes the dry run commands to the writer
+// supplied by the caller, and nothing to the results writer.
+func TestCheck(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.17")
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	opts := compatible.Options{Mode: "vet", DryRun: true, Stdout: stdout}
+	_, err = check(context.Background(), stderr, releases, []string{"./..."},
+		opts, output{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	var want string
+	for _, rel := range releases {
+		want += "GOROOT=" + rel.GoRoot + " " +
+			filepath.Join(rel.GoRoot, "bin", "go") + " vet ./...\n"
+	}
+	if s := stdout.String(); s != want {
+		t.Errorf("want stdout = %q, got %q", want, s)
+	}
+	// The counts are not printed in dry run mode.
+	if s := stderr.String(); s != "" {
+		t.Errorf("want stderr = %q, got %q", "", s)
+	}
+}
+
+// TestVerbose tests that the goroot of the release is included in the header
+// when out.verbose is set.
+func TestVerbose(t *testing.T) {
+	res := newresult("go1.16", compatible.Fail, "diagnostics found", "FAIL")
+	res.Release.GoRoot = filepath.Join("sdk", "go1.16")
+	results := []compatible.Result{res}
+	setduration(results, time.Second)
+
+	buf := new(bytes.Buffer)
+	printresults(buf, results, compatible.Options{}, output{verbose: true})
+	want := "using go1.16 from " + res.Release.GoRoot + " (1s)\nFAIL\n\n" +
+		"1 releases, 1 failed, 0 skipped\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+}
+
+// TestCounts tests that the last line reports the counts of a mix of
+// passing, failing and skipped releases, also without a summary.
+func TestCounts(t *testing.T) {
+	results := []compatible.Result{
+		newresult("go1.0", compatible.Skip, "race detector not supported", ""),
+		newresult("go1.15", compatible.Fail, "diagnostics found", "FAIL"),
+		newresult("go1.16", compatible.Fail, "timeout", "timeout: killed after 1s"),
+		newresult("go1.17", compatible.Pass, "", ""),
+		newresult("go1.18", compatible.Pass, "", ""),
+	}
+	setduration(results, time.Second)
+
+	buf := new(bytes.Buffer)
+	printresults(buf, results, compatible.Options{}, output{})
+	want := "using go1.15 (1s)\nFAIL\n\n" +
+		"using go1.16 (1s)\ntimeout: killed after 1s\n\n" +
+		"5 releases, 2 failed, 1 skipped\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+}
+
+// TestProgress tests the progress lines printed for a small set of releases.
+func TestProgress(t *testing.T) {
+	opts := compatible.Options{Tags: "integration"}
+	buf := new(bytes.Buffer)
+	report := progress(buf, opts)
+	for i, goversion := range []string{"go1.16", "go1.17"} {
+		res := newresult(goversion, compatible.Pass, "", "")
+		ev := compatible.Event{N: i + 1, Total: 2, Release: res.Release}
+		report(ev)
+		ev.Kind = compatible.EventFinished
+		ev.Result = res
+		report(ev)
+	}
+
+	want := "[1/2] running go1.16 tags=integration...\n" +
+		"[2/2] running go1.17 tags=integration...\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want progress = %q, got %q", want, s)
+	}
+}
+
+// setduration sets the duration of all the results to d, so that the output
+// is reproducible.
+func setduration(results []compatible.Result, d time.Duration) {
+	for i := range results {
+		results[i].Duration = d
+	}
+}
+
+// newresult returns a result for the specified go version, with an empty
+// goroot.
+func newresult(goversion string, status compatible.Status, reason, msg string) compatible.Result {
+	res := compatible.Result{
+		Release: compatible.Release{
+			Version: version.Must(version.Parse(goversion)),
+		},
+		Status: status,
+		Reason: reason,
+	}
+	if msg != "" {
+		res.Msg = []byte(msg)
+	}
+
+	return res
+}
+
+// TestFailOn tests that the -fail-on categories select the results causing a
+// non zero exit status.
+func TestFailOn(t *testing.T) {
+	withtool := func(res compatible.Result, tool string) compatible.Result {
+		res.Tool = tool
+
+		return res
+	}
+	vet := withtool(newresult("go1.16", compatible.Fail, "diagnostics found", "x"), "vet")
+	build := withtool(newresult("go1.16", compatible.Fail, "diagnostics found", "x"), "build")
+	testres := withtool(newresult("go1.16", compatible.Fail, "diagnostics found", "x"), "test")
+	command := withtool(newresult("go1.16", compatible.Fail, "command failed", "x"), "list")
+	timeout := withtool(newresult("go1.16", compatible.Fail, "timeout", "x"), "test")
+	compile := withtool(newresult("go1.16", compatible.Fail, "does not compile", "x"), "vet")
+	skip := newresult("go1.0", compatible.Skip, "race detector not supported", "")
+	pass := newresult("go1.17", compatible.Pass, "", "")
+
+	var tests = []struct {
+		failon  string
+		results []compatible.Result
+		want    bool
+	}{
+		{"", []compatible.Result{pass, vet}, true},
+		{"", []compatible.Result{pass, skip}, false},
+		{"any", []compatible.Result{build, pass}, true},
+		{"any", []compatible.Result{pass}, false},
+		{"vet", []compatible.Result{vet}, true},
+		{"vet", []compatible.Result{build, testres, timeout}, false},
+		{"build", []compatible.Result{build}, true},
+		{"build", []compatible.Result{vet, testres}, false},
+		{"test", []compatible.Result{pass, testres}, true},
+		{"test", []compatible.Result{timeout}, false},
+		{"timeout", []compatible.Result{timeout}, true},
+		{"command", []compatible.Result{command}, true},
+		{"command", []compatible.Result{vet}, false},
+		{"skip", []compatible.Result{skip}, true},
+		{"skip", []compatible.Result{vet}, false},
+		{"any,skip", []compatible.Result{skip}, true},
+		{"compile", []compatible.Result{compile}, true},
+		{"compile", []compatible.Result{vet, build}, false},
+		{"vet", []compatible.Result{compile}, false},
+		{"any", []compatible.Result{compile}, true},
+		{"vet, build", []compatible.Result{testres, build}, true},
+	}
+	for _, test := range tests {
+		var set failset
+		if test.failon != "" {
+			if err := set.Set(test.failon); err != nil {
+				t.Fatalf("%q: expected err == nil, got %q", test.failon, err)
+			}
+		}
+		if got := set.failed(test.results); got != test.want {
+			t.Errorf("%q: want failed = %t, got %t", test.failon, test.want, got)
+		}
+	}
+
+	var set failset
+	for _, value := range []string{"lint", "vet,", ""} {
+		if err := set.Set(value); err == nil {
+			t.Errorf("%q: expected err != nil", value)
+		}
+	}
+	if err := set.Set("test,vet"); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if s := set.String(); s != "vet,test" {
+		t.Errorf("want set = %q, got %q", "vet,test", s)
+	}
+}
+
+// TestEnvlist tests that the -env flag only accepts KEY=VALUE values.
+func TestEnvlist(t *testing.T) {
+	var env envlist
+	for _, s := range []string{"GOFLAGS=-mod=mod", "CGO_ENABLED=0"} {
+		if err := env.Set(s); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", s, err)
+		}
+	}
+	want := envlist{"GOFLAGS=-mod=mod", "CGO_ENABLED=0"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("want env = %q, got %q", want, env)
+	}
+
+	for _, s := range []string{"=value", "GOFLAGS"} {
+		if err := env.Set(s); err == nil {
+			t.Errorf("%s: expected err != nil", s)
+		}
+	}
+}
+
+// TestNoPatterns tests that at least one package pattern is required, unless
+// the releases are only listed, and that the patterns must precede the "--"
+// separator.
+func TestNoPatterns(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "patterns")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		args []string
+		file string
+		list bool
+		want error
+	}{
+		{nil, "", false, errNoPatterns},
+		{nil, empty, false, errNoPatterns},
+		{[]string{"-"}, "", false, errNoPatterns},
+		{nil, "", true, nil},
+		{[]string{"./..."}, "", false, nil},
+		{[]string{"-mode", "test", "--", "-tags", "integration", "./..."}, "",
+			false, errPatternsAfterSep},
+		{[]string{"-mode", "test", "./...", "--", "-tags", "integration"}, "",
+			false, nil},
+	}
+	for _, test := range tests {
+		cmdargs, toolargs := splitargs(test.args)
+		fs := flag.NewFlagSet("go-compatible", flag.ContinueOnError)
+		fs.String("mode", "vet", "")
+		if err := fs.Parse(cmdargs); err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.args, err)
+		}
+		patterns, err := cmdpatterns(fs.Args(), test.file, strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.args, err)
+		}
+		if err := checkpatterns(patterns, toolargs, test.list); err != test.want {
+			t.Errorf("%q %q list=%t: want err = %v, got %v", test.args,
+				test.file, test.list, test.want, err)
+		}
+	}
+}
+
+// TestVersionlist tests that the -require flag accepts versions with and
+// without the "go" prefix.
+func TestVersionlist(t *testing.T) {
+	var l versionlist
+	for _, s := range []string{"1.20.1", "go1.21.0"} {
+		if err := l.Set(s); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", s, err)
+		}
+	}
+	if s := l.String(); s != "1.20.1 1.21.0" {
+		t.Errorf("want list = %q, got %q", "1.20.1 1.21.0", s)
+	}
+
+	if err := l.Set("latest"); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestExcludelist tests that the -exclude flag accepts tip and devel for the
+// development versions, in addition to the versions.
+func TestExcludelist(t *testing.T) {
+	var l excludelist
+	for _, s := range []string{"1.20.1", "tip", "go1.21.0", "devel"} {
+		if err := l.Set(s); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", s, err)
+		}
+	}
+	if !l.devel {
+		t.Error("want devel = true, got false")
+	}
+	if s := l.String(); s != "1.20.1 1.21.0 tip" {
+		t.Errorf("want list = %q, got %q", "1.20.1 1.21.0 tip", s)
+	}
+
+	if err := l.Set("gotip"); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestVetanalyzers tests the parsing of the -vet flag.
+func TestVetanalyzers(t *testing.T) {
+	analyzers, err := vetanalyzers("printf, -shadow=false,unusedresult")
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want := []string{"printf", "shadow=false", "unusedresult"}
+	if !reflect.DeepEqual(analyzers, want) {
+		t.Errorf("want analyzers = %q, got %q", want, analyzers)
+	}
+
+	for _, list := range []string{"printf,", "=false", "a b"} {
+		if _, err := vetanalyzers(list); err == nil {
+			t.Errorf("%q: expected err != nil", list)
+		}
+	}
+}
+
+// TestNoPatternsPrintEnv tests that no package pattern is required with
+// -print-env, like -list and -check-sdk, since the go tool is not invoked.
+func TestNoPatternsPrintEnv(t *testing.T) {
+	if err := checkpatterns(nil, nil, notool()); err != errNoPatterns {
+		t.Fatalf("want err = %v, got %v", errNoPatterns, err)
+	}
+
+	defer func(v bool) { *printenvf = v }(*printenvf)
+	if err := flag.CommandLine.Parse([]string{"-print-env", "-env", "FOO=1"}); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	defer func() { envflag = nil }()
+	if err := checkpatterns(flag.Args(), nil, notool()); err != nil {
+		t.Errorf("want err = nil, got %v", err)
+	}
+}
+
+// TestSplitargs tests that the command line arguments are split at the first
+// "--" separator.
+func TestSplitargs(t *testing.T) {
+	var tests = []struct {
+		args   []string
+		before []string
+		after  []string
+	}{
+		{[]string{"./..."}, []string{"./..."}, nil},
+		{
+			[]string{"-mode", "test", "--", "-tags", "integration", "./..."},
+			[]string{"-mode", "test"},
+			[]string{"-tags", "integration", "./..."},
+		},
+		{
+			[]string{"./...", "--", "-run", "X", "--", "-v"},
+			[]string{"./..."},
+			[]string{"-run", "X", "--", "-v"},
+		},
+	}
+	for _, test := range tests {
+		before, after := splitargs(test.args)
+		if !reflect.DeepEqual(before, test.before) {
+			t.Errorf("%q: want before = %q, got %q", test.args, test.before,
+				before)
+		}
+		if !reflect.DeepEqual(after, test.after) {
+			t.Errorf("%q: want after = %q, got %q", test.args, test.after,
+				after)
+		}
+	}
+}
+
+// TestList tests that the releases found in a fake sdk are listed in order,
+// respecting the since version.
+func TestList(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16", "go1.9", "go1.15.2", "go1.17beta1")
+
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{
+		Since: version.Must(version.Parse("go1.15")),
+	})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	buf := new(bytes.Buffer)
+	printlist(buf, releases)
+
+	want := "go1.15.2\t" + filepath.Join(sdk, "go1.15.2") + "\n" +
+		"go1.16\t" + filepath.Join(sdk, "go1.16") + "\n" +
+		"go1.17beta1\t" + filepath.Join(sdk, "go1.17beta1") + "\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want list = %q, got %q", want, s)
+	}
+}
+
+// TestCheckshuffle tests the validation of the -shuffle flag.
+func TestCheckshuffle(t *testing.T) {
+	for _, value := range []string{"", "on", "off", "42", "-1"} {
+		if err := checkshuffle(value); err != nil {
+			t.Errorf("%q: expected err == nil, got %q", value, err)
+		}
+	}
+	for _, value := range []string{"yes", "1.5", "on,off"} {
+		if err := checkshuffle(value); err == nil {
+			t.Errorf("%q: expected err != nil", value)
+		}
+	}
+}
+
+// TestSelectversions tests that only the releases listed with the -versions
+// flag are selected, and that the versions not installed are reported.
+func TestSelectversions(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.17", "go1.18", "go1.19", "go1.20.3", "go1.21.0")
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+
+	var tests = []struct {
+		list    string
+		want    []string
+		missing string
+	}{
+		{"1.18,1.20.3,go1.21", []string{"go1.18", "go1.20.3", "go1.21.0"}, ""},
+		{"1.21, 1.17", []string{"go1.17", "go1.21.0"}, ""},
+		{"1.18,1.20,1.22", []string{"go1.18"}, "1.20 1.22.0"},
+	}
+	for _, test := range tests {
+		list, err := parseversions(test.list)
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.list, err)
+		}
+		selected, missing := selectversions(releases, list)
+		fakesdk.ValidateReleases(t, selected, test.want)
+		if s := versionlist(missing).String(); s != test.missing {
+			t.Errorf("%q: want missing = %q, got %q", test.list, test.missing, s)
+		}
+	}
+
+	for _, list := range []string{"", "1.18,", "1.18,x"} {
+		if _, err := parseversions(list); err == nil {
+			t.Errorf("%q: expected err != nil", list)
+		}
+	}
+}
+
+// TestMissingReleases tests that the required versions are reported unless a
+// selected release has exactly the same version.
+func TestMissingReleases(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.18.10", "go1.19rc1", "go1.20", "go1.21.3")
+
+	var tests = []struct {
+		constraint string
+		required   []string
+		want       string
+	}{
+		{"", []string{"1.18.10", "1.20", "1.21.3"}, ""},
+		{"", []string{"1.18", "1.19", "1.21.4"}, "1.18 1.19 1.21.4"},
+		{"", []string{"1.19rc1", "1.21.3"}, ""},
+		{"", []string{"1.21.0", "1.21.2"}, "1.21.0 1.21.2"},
+		{"", []string{"1.18.11", "1.17"}, "1.18.11 1.17"},
+		{">=1.19", []string{"1.18.10", "1.21.3"}, "1.18.10"},
+	}
+	for _, test := range tests {
+		var constraint version.Constraint
+		if test.constraint != "" {
+			if err := constraint.Set(test.constraint); err != nil {
+				t.Fatalf("%q: expected err == nil, got %q", test.constraint, err)
+			}
+		}
+		releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{
+			Constraint: constraint,
+		})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		var required versionlist
+		for _, s := range test.required {
+			if err := required.Set(s); err != nil {
+				t.Fatalf("%q: expected err == nil, got %q", s, err)
+			}
+		}
+
+		missing := missingreleases(releases, required)
+		if s := versionlist(missing).String(); s != test.want {
+			t.Errorf("%v: want missing = %q, got %q", test.required, test.want, s)
+		}
+	}
+}
+
+// TestComparesdk tests that comparesdk reports the release that changed from
+// pass to fail between two sdk directories.
+func TestComparesdk(t *testing.T) {
+	tree := func(outcomes ...string) string {
+		sdk := t.TempDir()
+		for _, outcome := range outcomes {
+			// The outcome is the go version followed by ":pass" or ":fail".
+			i := strings.Index(outcome, ":")
+			goversion := outcome[:i]
+			script := "exit 0"
+			if outcome[i+1:] == "fail" {
+				script = "echo FAIL; exit 1"
+			}
+			script = "if [ \"$1\" = version ]; then echo go version " +
+				goversion + " linux/amd64; exit 0; fi\n" + script
+			fakesdk.Goroot(t, filepath.Join(sdk, goversion), script)
+		}
+
+		return sdk
+	}
+	oldsdk := tree("go1.19:pass", "go1.20:pass", "go1.21.0:pass")
+	newsdk := tree("go1.20:pass", "go1.21.0:fail", "go1.22.0:fail")
+
+	var releases [2][]compatible.Release
+	for i, sdk := range []string{oldsdk, newsdk} {
+		list, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+		if err != nil {
+			t.Fatalf("expected err == nil, got %q", err)
+		}
+		releases[i] = list
+	}
+
+	buf := new(bytes.Buffer)
+	opts := compatible.Options{Mode: "test"}
+	failed, err := comparesdk(context.Background(), buf, releases[0],
+		releases[1], []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if !failed {
+		t.Error("expected failed == true")
+	}
+	want := "regression: go1.21.0: PASS -> FAIL\n" +
+		"1 regressions, 0 fixes\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want report = %q, got %q", want, s)
+	}
+
+	// Swapping the directories, the regression becomes a fix.
+	buf.Reset()
+	failed, err = comparesdk(context.Background(), buf, releases[1],
+		releases[0], []string{"./..."}, opts)
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if failed {
+		t.Error("expected failed == false")
+	}
+	want = "fix: go1.21.0: FAIL -> PASS\n" +
+		"0 regressions, 1 fixes\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want report = %q, got %q", want, s)
+	}
+}
+
+// TestPrintenv tests that the environment printed with the -print-env flag
+// reflects the options.
+func TestPrintenv(t *testing.T) {
+	withEnv(t, "GO111MODULE", "")
+	withEnv(t, "GOFLAGS", "")
+	sdk := fakesdk.SDK(t, "go1.11", "go1.16")
+	releases, err := compatible.DiscoverReleases(sdk, compatible.Filter{})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	goroot11 := filepath.Join(sdk, "go1.11")
+	goroot16 := filepath.Join(sdk, "go1.16")
+
+	opts := compatible.Options{
+		Platforms:  []compatible.Platform{{GOOS: "linux", GOARCH: "arm64"}},
+		Env:        []string{"CGO_ENABLED=0"},
+		Proxy:      "off",
+		VersionEnv: true,
+	}
+	buf := new(bytes.Buffer)
+	if err := printenv(buf, releases, opts); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want := "go1.11 linux/arm64\n" +
+		"\tGO111MODULE=on\n" +
+		"\tGOFLAGS=-mod=readonly\n" +
+		"\tGOPROXY=off\n" +
+		"\tCGO_ENABLED=0\n" +
+		"\tGOROOT=" + goroot11 + "\n" +
+		"\tGOOS=linux\n" +
+		"\tGOARCH=arm64\n" +
+		"go1.16 linux/arm64\n" +
+		"\tGOPROXY=off\n" +
+		"\tCGO_ENABLED=0\n" +
+		"\tGOROOT=" + goroot16 + "\n" +
+		"\tGOOS=linux\n" +
+		"\tGOARCH=arm64\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want env = %q, got %q", want, s)
+	}
+
+	// With a clean environment, the inherited variables are printed too.
+	opts = compatible.Options{CleanEnv: true}
+	buf.Reset()
+	if err := printenv(buf, releases[1:], opts); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	want = "\tPATH=" + os.Getenv("PATH") + "\n"
+	if s := buf.String(); !strings.Contains(s, want) {
+		t.Errorf("want env containing %q, got %q", want, s)
+	}
+	if s := buf.String(); !strings.HasSuffix(s, "\tGOROOT="+goroot16+"\n") {
+		t.Errorf("want env ending with GOROOT, got %q", s)
+	}
+}
+
+// TestPrintsdk tests the report printed with the -check-sdk flag.
+func TestPrintsdk(t *testing.T) {
+	sdk := fakesdk.SDK(t, "go1.16")
+	fakesdk.Goroot(t, filepath.Join(sdk, "gobad"), "echo go version bad linux/amd64")
+
+	buf := new(bytes.Buffer)
+	ok, err := printsdk(buf, sdk, "")
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if ok {
+		t.Error("expected ok == false")
+	}
+	want := "go1.16\t" + filepath.Join(sdk, "go1.16") + "\n" +
+		"error: " + filepath.Join(sdk, "gobad") +
+		": parse: version does not have the \"go\" prefix\n" +
+		"1 releases, 1 problems\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want report = %q, got %q", want, s)
+	}
+}
+
+// TestPrintBisect tests that printbisect reports the adjacent releases where
+// the result changes.
+func TestPrintBisect(t *testing.T) {
+	results := []compatible.Result{
+		newresult("go1.14", compatible.Fail, "diagnostics found", "FAIL"),
+		newresult("go1.15", compatible.Fail, "diagnostics found", "FAIL"),
+		newresult("go1.16", compatible.Pass, "", ""),
+		newresult("go1.18", compatible.Pass, "", ""),
+	}
+
+	buf := new(bytes.Buffer)
+	printbisect(buf, results, 2, compatible.Options{})
+	want := "result changes from FAIL in go1.15 to PASS in go1.16\n"
+	if s := buf.String(); s != want {
+		t.Errorf("want output = %q, got %q", want, s)
+	}
+}
+
+// TestSdkdir tests the precedence of the -sdk flag, the GOSDK environment
+// variable and the ~/sdk default.
+func TestSdkdir(t *testing.T) {
+	home := t.TempDir()
+	withEnv(t, "HOME", home)
+	withEnv(t, "USERPROFILE", home) // windows
+	withEnv(t, "GOSDK", "")
+	os.Unsetenv("GOSDK")
+
+	if dir, err := sdkdir(""); err != nil || dir != filepath.Join(home, "sdk") {
+		t.Errorf("home: want dir = %q, got %q (%v)", filepath.Join(home, "sdk"),
+			dir, err)
+	}
+
+	withEnv(t, "GOSDK", "/env/sdk")
+	if dir, err := sdkdir(""); err != nil || dir != "/env/sdk" {
+		t.Errorf("GOSDK: want dir = %q, got %q (%v)", "/env/sdk", dir, err)
+	}
+
+	if dir, err := sdkdir("/flag/sdk"); err != nil || dir != "/flag/sdk" {
+		t.Errorf("-sdk: want dir = %q, got %q (%v)", "/flag/sdk", dir, err)
+	}
+}
+
+// withEnv sets the environment variable key to value, restoring the original
+// value when the test completes.
+func withEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// TestPatternsFile tests that the patterns read from a file and from stdin are
+// appended to the patterns specified on the command line.
+func TestPatternsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "patterns")
+	data := "./cmd/...\n\n  ./internal/...\n"
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		args  []string
+		stdin string
+		want  []string
+	}{
+		{[]string{"."}, "", []string{".", "./cmd/...", "./internal/..."}},
+		{nil, "", []string{"./cmd/...", "./internal/..."}},
+		{[]string{"-"}, "./a\n./b\n", []string{"./a", "./b", "./cmd/...",
+			"./internal/..."}},
+	}
+	for _, test := range tests {
+		patterns, err := cmdpatterns(test.args, file,
+			strings.NewReader(test.stdin))
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.args, err)
+		}
+		if !reflect.DeepEqual(patterns, test.want) {
+			t.Errorf("%q: want patterns = %q, got %q", test.args, test.want,
+				patterns)
+		}
+	}
+
+	if _, err := cmdpatterns(nil, file+".missing", nil); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// withSDK sets gosdk to dir for the duration of the test.
+func withSDK(t *testing.T, dir string) {
+	old := gosdk
+	gosdk = dir
+	t.Cleanup(func() { gosdk = old })
+}
+
+// TestResolvePatterns tests the validation of the file system patterns and
+// their resolution to absolute paths.
+func TestResolvePatterns(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"cmd/tool", "internal"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	withWorkdir(t, root)
+
+	var tests = []struct {
+		pattern string
+		dir     string
+		want    string
+	}{
+		{".", "", root},
+		{"./...", "", root + "/..."},
+		{"./cmd/...", "", root + "/cmd/..."},
+		{"./cmd/tool", "", root + "/cmd/tool"},
+		{"./to...", "cmd", root + "/cmd/to..."},
+		{"./tool", "cmd", root + "/cmd/tool"},
+		{"../internal", "cmd", root + "/internal"},
+		{root + "/internal", "", root + "/internal"},
+		{"std", "", "std"},
+		{"example.com/mod/...", "", "example.com/mod/..."},
+	}
+	for _, test := range tests {
+		patterns := []string{test.pattern}
+		list, err := resolvepatterns(patterns, test.dir, false)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		if !reflect.DeepEqual(list, patterns) {
+			t.Errorf("%s: want patterns unchanged, got %q", test.pattern, list)
+		}
+
+		list, err = resolvepatterns(patterns, test.dir, true)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+		if want := filepath.FromSlash(test.want); list[0] != want {
+			t.Errorf("%s in %q: want pattern = %q, got %q", test.pattern,
+				test.dir, want, list[0])
+		}
+	}
+
+	for _, pattern := range []string{"./missing", "./missing/...", root + "/missing"} {
+		if _, err := resolvepatterns([]string{pattern}, "", false); err == nil {
+			t.Errorf("%s: expected err != nil", pattern)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a set of comparisons that a version must satisfy, like
+// ">=1.18 <1.22".  The zero value matches all the versions.
+type Constraint struct {
+	terms []term
+}
+
+// term is a single comparison in a constraint.
+type term struct {
+	op string // one of "=", "<", "<=", ">", ">="
+	v  Version
+}
+
+// operators contains the supported comparison operators.  Longer operators
+// come first, so that they are matched before their prefixes.
+var operators = []string{">=", "<=", ">", "<", "="}
+
+// ParseConstraint parses a constraint.
+func ParseConstraint(s string) (Constraint, error) {
+	// Grammar for a constraint:
+	//   constraint = term *(" " term)
+	//   term       = op version
+	//   op         = ">=" / "<=" / ">" / "<" / "="
+	//
+	// As an example:
+	// >=1.18 <1.22
+	// >= go1.18 < go1.22
+	// =1.21.3
+	//
+	// The version may omit the "go" prefix and may be separated from the
+	// operator by spaces.
+	var c Constraint
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return c, fmt.Errorf("parse constraint: empty constraint")
+	}
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		op := operator(field)
+		if op == "" {
+			return c, fmt.Errorf("parse constraint: missing operator in %q", field)
+		}
+
+		text := field[len(op):]
+		if text == "" {
+			// The version is in the next field.
+			if i+1 == len(fields) {
+				return c, fmt.Errorf("parse constraint: missing version after %q", op)
+			}
+			i++
+			text = fields[i]
+		}
+		if !strings.HasPrefix(text, "go") {
+			text = "go" + text
+		}
+		v, err := Parse(text)
+		if err != nil {
+			return c, fmt.Errorf("parse constraint: %v", err)
+		}
+
+		c.terms = append(c.terms, term{op: op, v: v})
+	}
+
+	return c, nil
+}
+
+// operator returns the comparison operator at the start of s, or an empty
+// string if s does not start with an operator.
+func operator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+
+	return ""
+}
+
+// Matches returns true if v satisfies all the comparisons in c.
+func (c Constraint) Matches(v Version) bool {
+	for _, t := range c.terms {
+		if !t.matches(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matches returns true if v satisfies the comparison t.
+func (t term) matches(v Version) bool {
+	c := v.Compare(t.v)
+	switch t.op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+
+	return false // should not be reached
+}
+
+// String implements the Stringer interface.
+func (c Constraint) String() string {
+	terms := make([]string, 0, len(c.terms))
+	for _, t := range c.terms {
+		terms = append(terms, t.op+t.v.String())
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// Set implements the Value interface.
+func (c *Constraint) Set(s string) error {
+	d, err := ParseConstraint(s)
+	if err != nil {
+		return err
+	}
+	*c = d
+
+	return nil
+}
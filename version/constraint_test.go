@@ -0,0 +1,100 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"testing"
+)
+
+// TestParseConstraint tests the ParseConstraint function and the
+// Constraint.String method.
+func TestParseConstraint(t *testing.T) {
+	var tests = []struct {
+		constraint string
+		want       string
+	}{
+		{">=1.18 <1.20", ">=1.18 <1.20"},
+		{">=1.18 <1.22", ">=1.18 <1.22.0"},
+		{">= go1.18 < go1.22", ">=1.18 <1.22.0"},
+		{"=1.21.3", "=1.21.3"},
+		{"<=1.21rc1 >1.16", "<=1.21rc1 >1.16"},
+	}
+	for _, test := range tests {
+		t.Run(test.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(test.constraint)
+			if err != nil {
+				t.Fatalf("expected err == nil, got %q", err)
+			}
+			if s := c.String(); s != test.want {
+				t.Errorf("c.String(): got %q, want %q", s, test.want)
+			}
+		})
+	}
+}
+
+// TestParseConstraintError tests that ParseConstraint returns an error for
+// invalid constraints.
+func TestParseConstraintError(t *testing.T) {
+	var tests = []string{
+		"",
+		"1.18",
+		">=",
+		">=1.18 <",
+		">=foo",
+		"!=1.18",
+	}
+	for _, test := range tests {
+		if _, err := ParseConstraint(test); err == nil {
+			t.Errorf("%q: expected err != nil", test)
+		}
+	}
+}
+
+// TestConstraintMatches tests the Constraint.Matches method.
+func TestConstraintMatches(t *testing.T) {
+	var tests = []struct {
+		constraint string
+		goversion  string
+		want       bool
+	}{
+		{">=1.18 <1.22", "go1.17", false},
+		{">=1.18 <1.22", "go1.18", true},
+		{">=1.18 <1.22", "go1.21.5", true},
+		{">=1.18 <1.22", "go1.22rc1", true},
+		{">=1.18 <1.22", "go1.22", false},
+		{">=1.18 <1.22", "go1.18beta1", false},
+		{"=1.21", "go1.21", true},
+		{"=1.21", "go1.21.0", true},
+		{"=1.21", "go1.21.1", false},
+		{">1.20 <=1.21", "go1.20", false},
+		{">1.20 <=1.21", "go1.20.1", true},
+		{">1.20 <=1.21", "go1.21", true},
+		{">1.20 <=1.21", "go1.21.1", false},
+	}
+	for _, test := range tests {
+		c := mustConstraint(t, test.constraint)
+		v := Must(Parse(test.goversion))
+		if got := c.Matches(v); got != test.want {
+			t.Errorf("%q matches %s: got %t, want %t", test.constraint,
+				test.goversion, got, test.want)
+		}
+	}
+
+	// The zero value matches all the versions.
+	var c Constraint
+	if !c.Matches(Must(Parse("go1.16"))) {
+		t.Error("zero constraint: expected a match")
+	}
+}
+
+// mustConstraint parses a constraint, stopping the test on errors.
+func mustConstraint(t *testing.T, s string) Constraint {
+	c, err := ParseConstraint(s)
+	if err != nil {
+		t.Fatalf("parse constraint %q: %v", s, err)
+	}
+
+	return c
+}
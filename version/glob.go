@@ -0,0 +1,123 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Glob is a glob pattern matching versions, like "1.20.*" or "1.2?".  The
+// zero value matches all the versions.
+type Glob struct {
+	pattern string
+}
+
+// ParseGlob parses a glob pattern.
+func ParseGlob(s string) (Glob, error) {
+	// The pattern is a version where:
+	//   "*" matches any sequence of characters, including "."
+	//   "?" matches a single character
+	//
+	// As an example:
+	// 1.20.*
+	// 1.2?
+	// go1.21rc*
+	//
+	// The "go" prefix is optional.
+	pattern := strings.TrimPrefix(s, "go")
+	if pattern == "" {
+		return Glob{}, fmt.Errorf("parse glob: empty pattern")
+	}
+	for _, r := range pattern {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'z', r == '.', r == '-':
+		case r == '*', r == '?':
+		default:
+			return Glob{}, fmt.Errorf("parse glob: invalid character %q in %q", r, s)
+		}
+	}
+
+	return Glob{pattern: pattern}, nil
+}
+
+// Matches returns true if v matches the pattern of g.
+//
+// The pattern is matched against the version formatted by String and with
+// the patch always included, so that "1.20.*" matches go1.20 and go1.20.3.
+// When the pattern has fewer dot-separated elements than the version, it is
+// also matched against the leading elements, so that "1.2?" matches go1.21.3.
+func (g Glob) Matches(v Version) bool {
+	if g.pattern == "" {
+		return true
+	}
+
+	n := strings.Count(g.pattern, ".") + 1
+	full := strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." +
+		strconv.Itoa(v.Patch) + v.PreRelease
+	for _, s := range []string{v.String(), full} {
+		if globmatch(g.pattern, s) || globmatch(g.pattern, leading(s, n)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String implements the Stringer interface.
+func (g Glob) String() string {
+	return g.pattern
+}
+
+// Set implements the Value interface.
+func (g *Glob) Set(s string) error {
+	h, err := ParseGlob(s)
+	if err != nil {
+		return err
+	}
+	*g = h
+
+	return nil
+}
+
+// globmatch returns true if s matches the glob pattern.
+func globmatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			// Try all the possible lengths of the sequence matched by "*".
+			for i := len(s); i >= 0; i-- {
+				if globmatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return s == ""
+}
+
+// leading returns the first n dot-separated elements of s.
+func leading(s string, n int) string {
+	elems := strings.SplitN(s, ".", n+1)
+	if len(elems) <= n {
+		return s
+	}
+
+	return strings.Join(elems[:n], ".")
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestGlobMatches tests the Glob.Matches method with patterns using * and ?.
+func TestGlobMatches(t *testing.T) {
+	versions := []string{
+		"go1.9", "go1.16", "go1.20", "go1.20.1", "go1.20.12", "go1.21rc2",
+		"go1.21.0", "go1.21.3", "go1.22.0",
+	}
+	var tests = []struct {
+		pattern string
+		want    []string
+	}{
+		{"1.20.*", []string{"1.20", "1.20.1", "1.20.12"}},
+		{"1.20.?", []string{"1.20", "1.20.1"}},
+		{"1.2?", []string{"1.20", "1.20.1", "1.20.12", "1.21rc2", "1.21.0",
+			"1.21.3", "1.22.0"}},
+		{"go1.21rc*", []string{"1.21rc2"}},
+		{"1.?", []string{"1.9"}},
+		{"1.*.0", []string{"1.9", "1.16", "1.20", "1.21.0", "1.22.0"}},
+		{"1.21.3", []string{"1.21.3"}},
+		{"1.16", []string{"1.16"}},
+		{"2.*", nil},
+	}
+	for _, test := range tests {
+		g, err := ParseGlob(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.pattern, err)
+		}
+
+		var got []string
+		for _, s := range versions {
+			if v := Must(Parse(s)); g.Matches(v) {
+				got = append(got, v.String())
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.pattern, got, test.want)
+		}
+	}
+
+	var g Glob
+	if !g.Matches(Must(Parse("go1.16"))) {
+		t.Error("expected the zero Glob to match")
+	}
+}
+
+// TestParseGlobError tests that ParseGlob returns an error for invalid
+// patterns.
+func TestParseGlobError(t *testing.T) {
+	for _, test := range []string{"", "go", "1.[0-9]", "^1.20", "1.20 1.21"} {
+		if _, err := ParseGlob(test); err == nil {
+			t.Errorf("%q: expected err != nil", test)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package version provides support for parsing and sorting go versions.
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// regex is based on the semver regex from https://regex101.com/r/Ly7O1x/3/.
+var regex = regexp.MustCompile(`^(?P<major>[1-9]\d*)\.(?P<minor>0|[1-9]\d*)(?:\.(?P<patch>0|[1-9]\d*))?(?P<prerelease>.*)$`)
+
+// Version represents a Go version.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string
+}
+
+// go121 is the first version where the go command includes the zero patch
+// in release names.
+var go121 = Version{Major: 1, Minor: 21}
+
+// Platform is the platform a go command was built for, as reported by go
+// version.
+type Platform struct {
+	GOOS   string
+	GOARCH string
+}
+
+// ParseLine parses the version line returned by go version.
+func ParseLine(line string) (Version, error) {
+	v, _, err := ParseLinePlatform(line)
+
+	return v, err
+}
+
+// ParseLinePlatform is like ParseLine, but additionally returns the platform
+// from the last field of the version line.  The platform is empty when the
+// last field is not in the "<os>/<arch>" format.
+func ParseLinePlatform(line string) (Version, Platform, error) {
+	// The line returned by go version for stable releases is:
+	//   "go version go<version> <os>/<arch>"
+	// For unstable releases it is:
+	//   "go version devel go<version> <timestamp> <os>/<arch>"
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "go" || fields[1] != "version" {
+		return Version{}, Platform{}, fmt.Errorf("parse: unexpected version line %q", line)
+	}
+	version := fields[2] // field after "go version"
+	if version == "devel" {
+		if len(fields) < 4 {
+			return Version{}, Platform{}, fmt.Errorf("parse: unexpected version line %q", line)
+		}
+		version = fields[3] // field after "go version devel"
+	}
+	v, err := Parse(version)
+	if err != nil {
+		return Version{}, Platform{}, err
+	}
+
+	return v, parseplatform(fields[len(fields)-1]), nil
+}
+
+// parseplatform parses a platform in the "<os>/<arch>" format.  It returns an
+// empty platform if s is not in this format.
+func parseplatform(s string) Platform {
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 || strings.Count(s, "/") != 1 {
+		return Platform{}
+	}
+
+	return Platform{GOOS: s[:i], GOARCH: s[i+1:]}
+}
+
+// Parse parses the Go version.
+func Parse(version string) (v Version, err error) {
+	// ABNF for Go version:
+	//   "go" major "." minor ["." patch] [pre-release]
+	//
+	// As an example:
+	// go1.16
+	// go1.16.3
+	// go1.16beta1
+	// go1.17-3f4977bd58
+	//
+	// Note that "-" is considered part of the pre-release.
+	if !strings.HasPrefix(version, "go") {
+		return v, fmt.Errorf("parse: version does not have the \"go\" prefix")
+	}
+	version = version[2:] // strip the "go" prefix
+
+	r := regex.FindAllStringSubmatch(version, -1)
+	if r == nil {
+		return v, fmt.Errorf("parse: unable to parse version %s", version)
+	}
+	m := r[0]
+
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return v, fmt.Errorf("parse: invalid major in version %s", version)
+	}
+	minor, err := strconv.Atoi(m[2])
+	if err != nil {
+		return v, fmt.Errorf("parse: invalid minor in version %s", version)
+	}
+	patch := 0
+	if m[3] != "" {
+		patch, err = strconv.Atoi(m[3])
+		if err != nil {
+			return v, fmt.Errorf("parse: invalid patch in version %s", version)
+		}
+	}
+	v = Version{
+		Major:      major,
+		Minor:      minor,
+		Patch:      patch,
+		PreRelease: m[4],
+	}
+
+	return v, nil
+}
+
+// ParseLang parses the language version in the go directive of a go.mod file,
+// like "1.21" or "1.21.0", without the "go" prefix.
+func ParseLang(s string) (Version, error) {
+	if strings.HasPrefix(s, "go") {
+		return Version{}, fmt.Errorf("parse: language version %s has the \"go\" prefix", s)
+	}
+
+	return Parse("go" + s)
+}
+
+// ParseToolchain parses the toolchain name in the toolchain directive of a
+// go.mod file, like "go1.21.5".  Unlike the language version, the toolchain
+// name has the "go" prefix.
+func ParseToolchain(s string) (Version, error) {
+	return Parse(s)
+}
+
+// Compare returns an integer comparing two versions according to version
+// precedence.  The major is compared first, so that go2.0 is more recent
+// than all the go1 releases.  A development build, like go1.17-3f4977bd58, is
+// more recent than the go1.17 release and pre-releases.
+// The result will be 0 if v == w, -1 if v < w, or +1 if v > w.
+func (v Version) Compare(w Version) int {
+	if c := intcmp(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := intcmp(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := intcmp(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+
+	return precmp(v.PreRelease, w.PreRelease)
+}
+
+// CompareMinor is like Compare, but only compares Major and Minor, ignoring
+// Patch and PreRelease.  As an example, go1.21.3 and go1.21rc1 have the same
+// minor.
+func (v Version) CompareMinor(w Version) int {
+	if c := intcmp(v.Major, w.Major); c != 0 {
+		return c
+	}
+
+	return intcmp(v.Minor, w.Minor)
+}
+
+// Equal returns true if v == w according to version precedence.
+func (v Version) Equal(w Version) bool {
+	return v.Compare(w) == 0
+}
+
+// Less returns true if v < w according to version precedence.
+func (v Version) Less(w Version) bool {
+	return v.Compare(w) < 0
+}
+
+// Versions is a list of versions that implements the sort.Interface, sorting
+// in ascending order of precedence.
+type Versions []Version
+
+// Len implements the sort.Interface interface.
+func (l Versions) Len() int {
+	return len(l)
+}
+
+// Less implements the sort.Interface interface.
+func (l Versions) Less(i, j int) bool {
+	return l[i].Less(l[j])
+}
+
+// Swap implements the sort.Interface interface.
+func (l Versions) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// Sort sorts a list of versions in ascending order of precedence.
+func Sort(list []Version) {
+	sort.Stable(Versions(list))
+}
+
+// IsPreRelease returns true if v is a pre-release, like go1.16beta1.
+func (v Version) IsPreRelease() bool {
+	return v.PreRelease != ""
+}
+
+// IsStable returns true if v is a stable release, that is not a pre-release.
+func (v Version) IsStable() bool {
+	return !v.IsPreRelease()
+}
+
+// Release returns v with PreRelease cleared.  As an example, the release of
+// go1.17rc1 is go1.17.
+func (v Version) Release() Version {
+	v.PreRelease = ""
+
+	return v
+}
+
+// NextMinor returns the first release of the minor version following v, with
+// Patch and PreRelease set to zero.  As an example, the next minor of
+// go1.16.3 is go1.17.
+func (v Version) NextMinor() Version {
+	return Version{Major: v.Major, Minor: v.Minor + 1}
+}
+
+// PrevMinor returns the first release of the minor version preceding v, with
+// Patch and PreRelease set to zero.  As an example, the previous minor of
+// go1.16.3 is go1.15.
+//
+// PrevMinor only decrements Minor, so the previous minor of go1.0 has a
+// negative Minor.
+func (v Version) PrevMinor() Version {
+	return Version{Major: v.Major, Minor: v.Minor - 1}
+}
+
+// Minors returns the first release of each minor version between v and w,
+// inclusive, in ascending order.  Patch and PreRelease of v and w are ignored.
+// Minors returns nil if v and w have different Major or if the minor of w is
+// less than the minor of v.
+func Minors(v, w Version) []Version {
+	if v.Major != w.Major {
+		return nil
+	}
+
+	var list []Version
+	for m := v.Minor; m <= w.Minor; m++ {
+		list = append(list, Version{Major: v.Major, Minor: m})
+	}
+
+	return list
+}
+
+// String implements the Stringer interface.
+//
+// String uses the same format as the go command: starting with go1.21 the
+// patch is always included in releases, so that go1.21 and go1.21.0 are both
+// formatted as 1.21.0, while go1.20.0 is formatted as 1.20.  The same applies
+// to all the releases with a major greater than 1, like 2.0.0.
+func (v Version) String() string {
+	s := strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+	if v.Patch > 0 || (v.PreRelease == "" && !v.Less(go121)) {
+		s += "." + strconv.Itoa(v.Patch)
+	}
+	if v.PreRelease != "" {
+		s += v.PreRelease
+	}
+
+	return s
+}
+
+// FullString is like String, but the patch is always included, as in
+// Major.Minor.Patch followed by the pre-release, so that go1.20 is formatted
+// as 1.20.0 and go1.21rc1 as 1.21.0rc1.  Note that the go command does not
+// use this format for pre-releases.
+func (v Version) FullString() string {
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." +
+		strconv.Itoa(v.Patch) + v.PreRelease
+}
+
+// Must is a helper that wraps a call to a function returning (Version, error)
+// and panics if the error is non-nil.
+func Must(v Version, err error) Version {
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// Set implements the Value interface.  The "go" prefix is optional, so that
+// both "go1.18" and "1.18" are accepted.
+func (v *Version) Set(s string) error {
+	if !strings.HasPrefix(s, "go") {
+		s = "go" + s
+	}
+	w, err := Parse(s)
+	if err != nil {
+		return fmt.Errorf("must be a go version, like 1.18 or go1.18.3")
+	}
+	*v = w
+
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface, using the same
+// format as String.
+func (v Version) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.  As with
+// Set, the "go" prefix is optional.
+func (v *Version) UnmarshalText(text []byte) error {
+	s := string(text)
+	if !strings.HasPrefix(s, "go") {
+		s = "go" + s
+	}
+	w, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*v = w
+
+	return nil
+}
+
+// intcmp compares two integers.
+func intcmp(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// strcmp compares two strings.
+func strcmp(x, y string) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
+
+// precmp compare two pre-releases.
+//
+// A development build, where the pre-release is a commit hash like
+// "-3f4977bd58", is built after the corresponding release and its named
+// pre-releases, and therefore it is more recent than them.  Since commit
+// hashes are not ordered, two development builds are compared as strings.
+func precmp(x, y string) int {
+	xdev, ydev := isdevel(x), isdevel(y)
+	switch {
+	case x == y:
+		return 0
+	case xdev && ydev:
+		return strcmp(x, y)
+	case xdev:
+		return 1
+	case ydev:
+		return -1
+	case x == "":
+		return 1
+	case y == "":
+		return -1
+	}
+
+	return strcmp(x, y)
+}
+
+// isdevel returns true if the pre-release is the commit hash of a development
+// build, like "-3f4977bd58".
+func isdevel(pre string) bool {
+	return strings.HasPrefix(pre, "-")
+}
@@ -0,0 +1,560 @@
+// Copyright 2021 Manlio Perillo. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"encoding"
+	"encoding/json"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestParse tests the Parse function and the Version.String method.
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		goversion  string
+		version    string
+		major      int
+		minor      int
+		patch      int
+		prerelease string
+		full       string
+	}{
+		{"go1.16", "1.16", 1, 16, 0, "", "1.16.0"},
+		{"go1.16.1", "1.16.1", 1, 16, 1, "", "1.16.1"},
+		{"go1.6beta1", "1.6beta1", 1, 6, 0, "beta1", "1.6.0beta1"},
+		{"go1.17-3f4977bd58", "1.17-3f4977bd58", 1, 17, 0, "-3f4977bd58", "1.17.0-3f4977bd58"},
+		{"go1.20.0", "1.20", 1, 20, 0, "", "1.20.0"},
+		{"go1.21.0", "1.21.0", 1, 21, 0, "", "1.21.0"},
+		{"go1.21", "1.21.0", 1, 21, 0, "", "1.21.0"},
+		{"go1.21.3", "1.21.3", 1, 21, 3, "", "1.21.3"},
+		{"go1.21rc2", "1.21rc2", 1, 21, 0, "rc2", "1.21.0rc2"},
+	}
+	for _, test := range tests {
+		t.Run(test.goversion, func(t *testing.T) {
+			v, err := Parse(test.goversion)
+			if err != nil {
+				t.Fatalf("expected err == nil, got %q", err)
+			}
+
+			if v.Major != test.major {
+				t.Errorf("v.Major: got %d, want %d", v.Major, test.major)
+			}
+			if v.Minor != test.minor {
+				t.Errorf("v.Minor: got %d, want %d", v.Minor, test.minor)
+			}
+			if v.Patch != test.patch {
+				t.Errorf("v.Patch: got %d, want %d", v.Patch, test.patch)
+			}
+			if v.PreRelease != test.prerelease {
+				t.Errorf("v.PreRelease: got %s, want %s", v.PreRelease,
+					test.prerelease)
+			}
+
+			if s := v.String(); s != test.version {
+				t.Errorf("v.String(): got %q, want %q", s, test.version)
+			}
+			if s := v.FullString(); s != test.full {
+				t.Errorf("v.FullString(): got %q, want %q", s, test.full)
+			}
+		})
+	}
+}
+
+// TestParseLine tests that ParseLine parses the version line returned by go
+// version, and that it returns an error for unexpected lines.
+func TestParseLine(t *testing.T) {
+	var tests = []struct {
+		line string
+		want string
+	}{
+		{"go version go1.16.3 linux/amd64", "1.16.3"},
+		{"go version devel go1.18-3f4977bd58 Tue Aug 3 10:12:44 2021 +0000 linux/amd64",
+			"1.18-3f4977bd58"},
+	}
+	for _, test := range tests {
+		v, err := ParseLine(test.line)
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.line, err)
+		}
+		if s := v.String(); s != test.want {
+			t.Errorf("%q: got %q, want %q", test.line, s, test.want)
+		}
+	}
+
+	for _, line := range []string{"", "hello", "go version", "go version devel",
+		"version go1.16 linux/amd64"} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("%q: expected err != nil", line)
+		}
+	}
+}
+
+// TestParseLinePlatform tests the ParseLinePlatform function, parsing the
+// platform from the version line.
+func TestParseLinePlatform(t *testing.T) {
+	var tests = []struct {
+		line string
+		want string
+		plat Platform
+	}{
+		{"go version go1.16.3 linux/amd64", "1.16.3", Platform{"linux", "amd64"}},
+		{"go version go1.21.0 windows/arm64", "1.21.0", Platform{"windows", "arm64"}},
+		{"go version devel go1.18-3f4977bd58 Tue Aug 3 10:12:44 2021 +0000 darwin/arm64",
+			"1.18-3f4977bd58", Platform{"darwin", "arm64"}},
+		{"go version go1.16", "1.16", Platform{}},
+		{"go version go1.16 amd64", "1.16", Platform{}},
+		{"go version go1.16 linux/", "1.16", Platform{}},
+	}
+	for _, test := range tests {
+		v, plat, err := ParseLinePlatform(test.line)
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.line, err)
+		}
+		if s := v.String(); s != test.want {
+			t.Errorf("%q: want version = %q, got %q", test.line, test.want, s)
+		}
+		if plat != test.plat {
+			t.Errorf("%q: want platform = %+v, got %+v", test.line, test.plat, plat)
+		}
+	}
+
+	if _, _, err := ParseLinePlatform("go version devel"); err == nil {
+		t.Errorf("expected err != nil")
+	}
+}
+
+// TestParseLang tests the ParseLang and ParseToolchain functions, parsing the
+// go and toolchain directives of a go.mod file.
+func TestParseLang(t *testing.T) {
+	var tests = []struct {
+		s    string
+		want string
+	}{
+		{"1.16", "1.16"},
+		{"1.21", "1.21.0"},
+		{"1.21.0", "1.21.0"},
+		{"1.21rc1", "1.21rc1"},
+	}
+	for _, test := range tests {
+		v, err := ParseLang(test.s)
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.s, err)
+		}
+		if s := v.String(); s != test.want {
+			t.Errorf("%s: got %q, want %q", test.s, s, test.want)
+		}
+
+		w, err := ParseToolchain("go" + test.s)
+		if err != nil {
+			t.Fatalf("go%s: expected err == nil, got %q", test.s, err)
+		}
+		if w != v {
+			t.Errorf("go%s: got %#v, want %#v", test.s, w, v)
+		}
+	}
+
+	if _, err := ParseLang("go1.21"); err == nil {
+		t.Error("go1.21: expected err != nil")
+	}
+	for _, s := range []string{"1.21.5", "default", "local"} {
+		if _, err := ParseToolchain(s); err == nil {
+			t.Errorf("%s: expected err != nil", s)
+		}
+	}
+}
+
+// TestIsPreRelease tests the Version.IsPreRelease, Version.IsStable and
+// Version.Release methods.
+func TestIsPreRelease(t *testing.T) {
+	var tests = []struct {
+		goversion string
+		want      bool
+		release   string
+	}{
+		{"go1.16", false, "1.16"},
+		{"go1.16.1", false, "1.16.1"},
+		{"go1.6beta1", true, "1.6"},
+		{"go1.17rc1", true, "1.17"},
+		{"go1.21rc2", true, "1.21.0"},
+		{"go1.17-3f4977bd58", true, "1.17"},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.goversion))
+		if got := v.IsPreRelease(); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.goversion, got, test.want)
+		}
+		if got := v.IsStable(); got == test.want {
+			t.Errorf("%s: IsStable: got %t, want %t", test.goversion, got,
+				!test.want)
+		}
+		if s := v.Release().String(); s != test.release {
+			t.Errorf("%s: Release: got %q, want %q", test.goversion, s,
+				test.release)
+		}
+	}
+}
+
+// TestMinor tests the Version.NextMinor and Version.PrevMinor methods.
+func TestMinor(t *testing.T) {
+	var tests = []struct {
+		goversion string
+		next      string
+		prev      string
+	}{
+		{"go1.16", "1.17", "1.15"},
+		{"go1.21.3", "1.22.0", "1.20"},
+		{"go1.16.3", "1.17", "1.15"},
+		{"go1.17rc1", "1.18", "1.16"},
+		{"go1.9", "1.10", "1.8"},
+		{"go1.1", "1.2", "1.0"},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.goversion))
+		if s := v.NextMinor().String(); s != test.next {
+			t.Errorf("%s: NextMinor: got %q, want %q", test.goversion, s,
+				test.next)
+		}
+		if s := v.PrevMinor().String(); s != test.prev {
+			t.Errorf("%s: PrevMinor: got %q, want %q", test.goversion, s,
+				test.prev)
+		}
+	}
+}
+
+// TestMinors tests the Minors function.
+func TestMinors(t *testing.T) {
+	var tests = []struct {
+		from string
+		to   string
+		want []string
+	}{
+		{"go1.16", "go1.16", []string{"1.16"}},
+		{"go1.16.3", "go1.19rc1", []string{"1.16", "1.17", "1.18", "1.19"}},
+		{"go1.20", "go1.22.1", []string{"1.20", "1.21.0", "1.22.0"}},
+		{"go1.19", "go1.16", nil},
+		{"go1.16", "go2.0", nil},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.from))
+		w := Must(Parse(test.to))
+
+		var got []string
+		for _, m := range Minors(v, w) {
+			got = append(got, m.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s..%s: got %q, want %q", test.from, test.to, got,
+				test.want)
+		}
+	}
+}
+
+// TestToolchainFormat tests that versions starting with go1.21 round-trip with
+// an explicit zero patch, and that they sort equal to the version without the
+// patch.
+func TestToolchainFormat(t *testing.T) {
+	v := Must(Parse("go1.21.0"))
+	if s := "go" + v.String(); s != "go1.21.0" {
+		t.Errorf("got %q, want %q", s, "go1.21.0")
+	}
+	if w := Must(Parse("go" + v.String())); w != v {
+		t.Errorf("round-trip: got %#v, want %#v", w, v)
+	}
+
+	w := Must(Parse("go1.21"))
+	if !v.Equal(w) {
+		t.Errorf("expected %s == %s", v, w)
+	}
+	if v.Less(w) || w.Less(v) {
+		t.Errorf("expected %s and %s to sort equal", v, w)
+	}
+}
+
+// TestSort tests that the Sort function sorts versions according to version
+// precedence.
+func TestSort(t *testing.T) {
+	list := []Version{
+		Must(Parse("go1.17")),
+		Must(Parse("go1.16rc1")),
+		Must(Parse("go1.16.2")),
+		Must(Parse("go1.9")),
+		Must(Parse("go1.16")),
+		Must(Parse("go1.17beta1")),
+		Must(Parse("go1.16beta1")),
+		Must(Parse("go1.10")),
+		Must(Parse("go1.17-abcdef")),
+		Must(Parse("go1.16-3f4977bd58")),
+	}
+	want := []string{
+		"1.9", "1.10", "1.16beta1", "1.16rc1", "1.16", "1.16-3f4977bd58",
+		"1.16.2", "1.17beta1", "1.17", "1.17-abcdef",
+	}
+
+	Sort(list)
+	got := make([]string, 0, len(list))
+	for _, v := range list {
+		got = append(got, v.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestCompareMajor tests the ordering and the formatting of versions at the
+// major version boundaries.
+func TestCompareMajor(t *testing.T) {
+	var tests = []struct {
+		v, w string
+		want int
+	}{
+		{"go1.21.3", "go2.0", -1},
+		{"go1.99.99", "go2.0", -1},
+		{"go2.0", "go1.0", 1},
+		{"go2.0rc1", "go1.30", 1},
+		{"go2.0rc1", "go2.0", -1},
+		{"go2.0", "go2.0.0", 0},
+		{"go2.0-abcdef", "go2.0", 1},
+		{"go2.1", "go2.0.5", 1},
+		{"go2.10", "go2.9", 1},
+		{"go10.0", "go9.0", 1},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.v))
+		w := Must(Parse(test.w))
+		if c := v.Compare(w); c != test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.v, test.w, c, test.want)
+		}
+		if c := w.Compare(v); c != -test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.w, test.v, c, -test.want)
+		}
+	}
+
+	var formats = []struct {
+		goversion string
+		want      string
+	}{
+		{"go2.0", "2.0.0"},
+		{"go2.0.0", "2.0.0"},
+		{"go2.1.3", "2.1.3"},
+		{"go2.0rc1", "2.0rc1"},
+		{"go2.0-abcdef", "2.0-abcdef"},
+		{"go10.2", "10.2.0"},
+	}
+	for _, test := range formats {
+		if s := Must(Parse(test.goversion)).String(); s != test.want {
+			t.Errorf("%s: got %q, want %q", test.goversion, s, test.want)
+		}
+	}
+
+	list := []Version{
+		Must(Parse("go2.0")),
+		Must(Parse("go1.21.0")),
+		Must(Parse("go2.0rc1")),
+		Must(Parse("go1.9")),
+		Must(Parse("go2.1")),
+	}
+	Sort(list)
+	got := make([]string, 0, len(list))
+	for _, v := range list {
+		got = append(got, v.String())
+	}
+	want := []string{"1.9", "1.21.0", "2.0rc1", "2.0.0", "2.1.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestCompareDevel tests that a development build is more recent than the
+// corresponding release and pre-releases.
+func TestCompareDevel(t *testing.T) {
+	var tests = []struct {
+		v, w string
+		want int
+	}{
+		{"go1.17-abcdef", "go1.17", 1},
+		{"go1.17-abcdef", "go1.17rc1", 1},
+		{"go1.17beta1", "go1.17-abcdef", -1},
+		{"go1.17-abcdef", "go1.17.1", -1},
+		{"go1.17-abcdef", "go1.17-abcdef", 0},
+		{"go1.17-123456", "go1.17-abcdef", -1},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.v))
+		w := Must(Parse(test.w))
+		if c := v.Compare(w); c != test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.v, test.w, c, test.want)
+		}
+	}
+}
+
+// TestCompareMinor tests that the Version.CompareMinor method ignores the
+// patch and the pre-release.
+func TestCompareMinor(t *testing.T) {
+	var tests = []struct {
+		v, w string
+		want int
+	}{
+		{"go1.21.3", "go1.21.0", 0},
+		{"go1.21.3", "go1.21rc1", 0},
+		{"go1.21-abcdef", "go1.21", 0},
+		{"go1.20", "go1.21", -1},
+		{"go1.21", "go1.20.12", 1},
+		{"go1.9", "go1.10", -1},
+		{"go2.0", "go1.21", 1},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.v))
+		w := Must(Parse(test.w))
+		if c := v.CompareMinor(w); c != test.want {
+			t.Errorf("%s vs %s: got %d, want %d", test.v, test.w, c, test.want)
+		}
+	}
+}
+
+// TestSet tests the Version.Set method, with and without the "go" prefix.
+func TestSet(t *testing.T) {
+	var tests = []struct {
+		s    string
+		want string
+	}{
+		{"go1.18", "1.18"},
+		{"1.18", "1.18"},
+		{"1.16.3", "1.16.3"},
+		{"go1.21rc1", "1.21rc1"},
+	}
+	for _, test := range tests {
+		var v Version
+		if err := v.Set(test.s); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.s, err)
+		}
+		if s := v.String(); s != test.want {
+			t.Errorf("%s: got %q, want %q", test.s, s, test.want)
+		}
+	}
+
+	var v Version
+	if err := v.Set("foo"); err == nil {
+		t.Error("expected err != nil")
+	}
+}
+
+// TestFlag tests that Version can be used as a flag, rejecting malformed
+// versions, and that the String output round-trips.
+func TestFlag(t *testing.T) {
+	var tests = []struct {
+		arg  string
+		want string
+		ok   bool
+	}{
+		{"go1.18", "1.18", true},
+		{"1.18.3", "1.18.3", true},
+		{"go1.21.0", "1.21.0", true},
+		{"foo", "", false},
+		{"1", "", false},
+		{"go", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		var v Version
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Var(&v, "since", "version")
+
+		err := fs.Parse([]string{"-since", test.arg})
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%q: expected err != nil", test.arg)
+			}
+
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: expected err == nil, got %q", test.arg, err)
+		}
+		if s := v.String(); s != test.want {
+			t.Errorf("%q: got %q, want %q", test.arg, s, test.want)
+		}
+
+		var w Version
+		if err := w.Set(v.String()); err != nil {
+			t.Fatalf("%q: round-trip: expected err == nil, got %q", test.arg,
+				err)
+		}
+		if w != v {
+			t.Errorf("%q: round-trip: got %#v, want %#v", test.arg, w, v)
+		}
+	}
+}
+
+// TestText tests that Version implements the encoding.TextMarshaler and
+// encoding.TextUnmarshaler interfaces, and that the text encoding round-trips
+// like Parse.
+func TestText(t *testing.T) {
+	var tests = []struct {
+		goversion string
+		text      string
+	}{
+		{"go1.16", "1.16"},
+		{"go1.16.1", "1.16.1"},
+		{"go1.6beta1", "1.6beta1"},
+		{"go1.17-3f4977bd58", "1.17-3f4977bd58"},
+		{"go1.20.0", "1.20"},
+		{"go1.21", "1.21.0"},
+		{"go1.21.3", "1.21.3"},
+		{"go1.21rc2", "1.21rc2"},
+	}
+	for _, test := range tests {
+		v := Must(Parse(test.goversion))
+		var m encoding.TextMarshaler = v
+		text, err := m.MarshalText()
+		if err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.goversion, err)
+		}
+		if string(text) != test.text {
+			t.Errorf("%s: got %q, want %q", test.goversion, text, test.text)
+		}
+
+		var w Version
+		var u encoding.TextUnmarshaler = &w
+		if err := u.UnmarshalText(text); err != nil {
+			t.Fatalf("%s: expected err == nil, got %q", test.goversion, err)
+		}
+		if p := Must(Parse("go" + v.String())); w != p {
+			t.Errorf("%s: round-trip: got %#v, want %#v", test.goversion, w, p)
+		}
+	}
+
+	var v Version
+	for _, text := range []string{"", "go", "1", "foo", "go1.x"} {
+		if err := v.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: expected err != nil", text)
+		}
+	}
+}
+
+// TestJSON tests that encoding/json uses the text encoding.
+func TestJSON(t *testing.T) {
+	type config struct {
+		Since Version
+	}
+	data, err := json.Marshal(config{Since: Must(Parse("go1.21rc2"))})
+	if err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if want := `{"Since":"1.21rc2"}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var c config
+	if err := json.Unmarshal([]byte(`{"Since":"go1.18.3"}`), &c); err != nil {
+		t.Fatalf("expected err == nil, got %q", err)
+	}
+	if want := Must(Parse("go1.18.3")); c.Since != want {
+		t.Errorf("got %#v, want %#v", c.Since, want)
+	}
+}